@@ -10,16 +10,37 @@ type TFAccessory struct {
 	Platform string           // Kasa, Tradfri, Tradfri-Device, Shelly, etc
 	Name     string           // the name used internally
 	IP       string           // the IP address of the device
-	Username string           // for Tradfri, Shelly -- the MAC for Konnected
-	Password string           // for Tradfri, Shelly -- the Token for Konnected
+	Username string           // for Tradfri, Shelly, Kasa (KLAP) -- the MAC for Konnected
+	Password string           // for Tradfri, Shelly, Kasa (KLAP) -- the Token for Konnected and Noonlight
 	Info     hcaccessory.Info // defined at https://github.com/brutella/hc/blob/master/accessory/accessory.go
 	Type     hcaccessory.AccessoryType
 
 	// relevant only to Konnected boards
-	KonnectedZones []Zone
+	KonnectedZones       []Zone
+	KonnectedNoBlink     bool           // provisioning: do not blink the board's LED when it sends
+	KonnectedNoDiscovery bool           // provisioning: do not answer SSDP discovery
+	KonnectedAlarm       *AlarmSettings // delays and siren, unset for the defaults
+
+	// relevant only to Noonlight
+	Noonlight *NoonlightSettings
+
+	// relevant only to Kasa devices
+	KasaTransition   uint32 // milliseconds for bulbs and dimmers to fade between states, 0 to use the device's default
+	KasaProtocol     string // "xor" (port 9999) or "klap" (newer firmware, port 80), unset to auto-detect
+	KasaLEDSwitch    bool   // add a switch for the status LED
+	KasaRebootSwitch bool   // add a switch which reboots the device
+	KasaCloudSensor  bool   // add a contact sensor which is open when the device is bound to a cloud account
+
+	// Kasa dimmers only, in milliseconds, 0 leaves the device's setting alone
+	KasaFadeOnTime    uint32 // button press on
+	KasaFadeOffTime   uint32 // button press off
+	KasaGentleOnTime  uint32 // long press on
+	KasaGentleOffTime uint32 // long press off
+	KasaDoubleClick   string // "none", "instant_on_off", or "gentle_on_off", unset leaves the device's setting alone
 
 	/* below this line are NOT set in config file */
-	*hcaccessory.Accessory // set when the device is added to HomeControl
+	*hcaccessory.Accessory        // set when the device is added to HomeControl
+	ConfigFile             string // set by the loader, for platforms which save generated settings back
 
 	Device interface{}
 }
@@ -27,8 +48,60 @@ type TFAccessory struct {
 // exposed in accessory.KonnectedZones
 type Zone struct {
 	Pin  uint8  `json:"pin"`
+	Zone string `json:"zone,omitempty"` // Konnected Pro: "1"-"12", "alarm1", "out1", or "alarm2_out2", used instead of pin
 	Name string `json:"name"`
-	Type string `json:"type"`
-	// Actuator actuator `json:"actuator",omitempty`
-	// Command  command  `json:"command",omitempty`
+	Type string `json:"type"` // motion, door, buzzer, switch, siren, strobe, garage, valve, temperature, humidity
+
+	// actuators only
+	Momentary uint16 `json:"momentary,omitempty"` // ms to stay on before turning back off, 0 to latch
+	Times     uint8  `json:"times,omitempty"`     // how many times to repeat a momentary pulse
+	Pause     uint16 `json:"pause,omitempty"`     // ms between repeats
+	Sensor    uint8  `json:"sensor,omitempty"`    // garage only: the pin of the door position sensor
+	ActiveLow bool   `json:"activelow,omitempty"` // the relay is triggered by a low pin
+
+	// motion and door only
+	Alarm  string   `json:"alarm,omitempty"`  // instant, delayed, follower, chime, or 24h -- unset is delayed for doors, chime for motion
+	Bypass []string `json:"bypass,omitempty"` // modes (stay, away, night) in which the zone is ignored
+
+	// temperature and humidity only
+	Probe   string `json:"probe,omitempty"`   // "ds18b20" or "dht"
+	Address string `json:"address,omitempty"` // ds18b20 only: the probe's address, unset matches any probe on the pin
+	Poll    uint   `json:"poll,omitempty"`    // minutes between readings, unset for 3
+}
+
+// AlarmSettings configure a Konnected board's security system
+type AlarmSettings struct {
+	Stay    AlarmDelays `json:"stay"`
+	Away    AlarmDelays `json:"away"`
+	Night   AlarmDelays `json:"night"`
+	Siren   uint16      `json:"siren"`   // seconds to sound the siren, 0 sounds until disarmed
+	Rearm   bool        `json:"rearm"`   // return to armed once the siren stops, otherwise stay triggered until disarmed
+	Offline bool        `json:"offline"` // the board going offline while armed trips the alarm
+}
+
+// AlarmDelays are per-mode, in seconds
+type AlarmDelays struct {
+	Entry uint16 `json:"entry"` // from a delayed zone opening to the alarm
+	Exit  uint16 `json:"exit"`  // from arming to armed
+}
+
+// NoonlightSettings describe who to call and where to send help
+type NoonlightSettings struct {
+	URL          string           `json:"url"`   // API base URL, unset for the sandbox
+	Name         string           `json:"name"`  // the account holder
+	Phone        string           `json:"phone"` // Noonlight calls this first
+	PIN          string           `json:"pin"`   // cancels an alarm
+	Address      NoonlightAddress `json:"address"`
+	Services     []string         `json:"services"`     // police, fire, medical, or other -- unset for police
+	Instructions string           `json:"instructions"` // for the responders, such as how to get in
+	AuditLog     string           `json:"auditlog"`     // every dispatch and cancel is appended here, unset for noonlight/audit.log
+}
+
+// NoonlightAddress is where the alarm is
+type NoonlightAddress struct {
+	Line1 string `json:"line1"`
+	Line2 string `json:"line2,omitempty"`
+	City  string `json:"city"`
+	State string `json:"state"`
+	Zip   string `json:"zip"`
 }
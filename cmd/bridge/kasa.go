@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cloudkucooland/toofar/config"
+	"github.com/cloudkucooland/toofar/kasa"
+
+	"github.com/urfave/cli/v2"
+)
+
+func kasaCommand() *cli.Command {
+	ruleFlags := []cli.Flag{
+		&cli.StringFlag{Name: "name", Usage: "rule name"},
+		&cli.StringFlag{Name: "action", Value: "on", Usage: "on or off"},
+		&cli.StringFlag{Name: "at", Value: "00:00", Usage: "HH:MM, sunrise, or sunset"},
+		&cli.StringFlag{Name: "until", Usage: "HH:MM, sunrise, or sunset -- end of an away mode period"},
+		&cli.StringFlag{Name: "days", Value: "daily", Usage: "daily, weekdays, weekends, or a list: sun,mon,..."},
+		&cli.BoolFlag{Name: "disable", Usage: "store the rule disabled"},
+	}
+
+	return &cli.Command{
+		Name:  "kasa",
+		Usage: "manage Kasa devices directly",
+		Before: func(c *cli.Context) error {
+			// the kasa helpers read their timeouts from the running config
+			config.Set(&config.Config{})
+			return nil
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name:  "provision",
+				Usage: "join a new device (in setup mode) to the network without the vendor app; lists visible networks if no SSID is given",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "ip", Value: kasa.SetupIP, Usage: "device IP address"},
+					&cli.StringFlag{Name: "ssid", Usage: "network to join"},
+					&cli.StringFlag{Name: "password", Usage: "network password"},
+					&cli.IntFlag{Name: "keytype", Usage: "0 to detect, 1 WEP, 2 WPA, 3 WPA2"},
+					&cli.StringFlag{Name: "alias", Usage: "name for the device"},
+					&cli.BoolFlag{Name: "unbind", Value: true, Usage: "remove any cloud binding"},
+				},
+				Action: func(c *cli.Context) error {
+					if c.String("ssid") == "" {
+						aps, err := kasa.ScanWifi(c.String("ip"))
+						if err != nil {
+							return err
+						}
+						for _, ap := range aps {
+							fmt.Fprintf(os.Stdout, "%s (key type %d)\n", ap.SSID, ap.KeyType)
+						}
+						return nil
+					}
+
+					err := kasa.Provision(c.String("ip"), kasa.ProvisionSettings{
+						SSID:     c.String("ssid"),
+						Password: c.String("password"),
+						KeyType:  c.Int("keytype"),
+						Alias:    c.String("alias"),
+						Unbind:   c.Bool("unbind"),
+					})
+					if err != nil {
+						return err
+					}
+					fmt.Fprintf(os.Stdout, "device is joining %s\n", c.String("ssid"))
+					return nil
+				},
+			},
+			{
+				Name:  "schedule",
+				Usage: "list, add, edit, and delete device-local rules",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "ip", Required: true, Usage: "device IP address"},
+					&cli.StringFlag{Name: "child", Usage: "child ID, to target one outlet of a strip"},
+					&cli.BoolFlag{Name: "away", Usage: "use the away mode (anti_theft) rules instead of the schedule"},
+				},
+				Subcommands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "show the rules stored on the device",
+						Action: func(c *cli.Context) error {
+							rules, err := kasa.Rules(c.String("ip"), c.String("child"), ruleModule(c))
+							if err != nil {
+								return err
+							}
+							out, err := json.MarshalIndent(rules, "", "  ")
+							if err != nil {
+								return err
+							}
+							fmt.Fprintln(os.Stdout, string(out))
+							return nil
+						},
+					},
+					{
+						Name:  "add",
+						Usage: "store a new rule on the device",
+						Flags: ruleFlags,
+						Action: func(c *cli.Context) error {
+							var r kasa.ScheduleRule
+							if err := applyRuleFlags(c, &r, true); err != nil {
+								return err
+							}
+							id, err := kasa.AddRule(c.String("ip"), c.String("child"), ruleModule(c), r)
+							if err != nil {
+								return err
+							}
+							fmt.Fprintf(os.Stdout, "added rule %s\n", id)
+							return nil
+						},
+					},
+					{
+						Name:  "edit",
+						Usage: "change a rule, only the flags given are changed",
+						Flags: append([]cli.Flag{&cli.StringFlag{Name: "id", Required: true}}, ruleFlags...),
+						Action: func(c *cli.Context) error {
+							rules, err := kasa.Rules(c.String("ip"), c.String("child"), ruleModule(c))
+							if err != nil {
+								return err
+							}
+							for _, r := range rules {
+								if r.ID != c.String("id") {
+									continue
+								}
+								if err := applyRuleFlags(c, &r, false); err != nil {
+									return err
+								}
+								return kasa.EditRule(c.String("ip"), c.String("child"), ruleModule(c), r)
+							}
+							return fmt.Errorf("no rule with ID %s", c.String("id"))
+						},
+					},
+					{
+						Name:  "delete",
+						Usage: "remove a single rule",
+						Flags: []cli.Flag{&cli.StringFlag{Name: "id", Required: true}},
+						Action: func(c *cli.Context) error {
+							return kasa.DeleteRule(c.String("ip"), c.String("child"), ruleModule(c), c.String("id"))
+						},
+					},
+					{
+						Name:  "purge",
+						Usage: "remove every rule",
+						Action: func(c *cli.Context) error {
+							return kasa.DeleteAllRules(c.String("ip"), c.String("child"), ruleModule(c))
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func ruleModule(c *cli.Context) string {
+	if c.Bool("away") {
+		return kasa.AwayMode
+	}
+	return kasa.Schedule
+}
+
+// applyRuleFlags sets the rule from the command line, all means set the defaults too (for new rules)
+func applyRuleFlags(c *cli.Context, r *kasa.ScheduleRule, all bool) error {
+	if all || c.IsSet("name") {
+		r.Name = c.String("name")
+	}
+	if all || c.IsSet("disable") {
+		r.Enable = 1
+		if c.Bool("disable") {
+			r.Enable = 0
+		}
+	}
+	if all || c.IsSet("action") {
+		switch c.String("action") {
+		case "on":
+			r.SAct = 1
+		case "off":
+			r.SAct = 0
+		default:
+			return fmt.Errorf("action must be on or off")
+		}
+	}
+	if all || c.IsSet("at") {
+		opt, min, err := parseRuleTime(c.String("at"))
+		if err != nil {
+			return err
+		}
+		r.StimeOpt, r.SMin = opt, min
+	}
+	if all || c.IsSet("until") {
+		r.EtimeOpt, r.EMin, r.EAct = -1, 0, -1
+		if c.String("until") != "" {
+			opt, min, err := parseRuleTime(c.String("until"))
+			if err != nil {
+				return err
+			}
+			r.EtimeOpt, r.EMin, r.EAct = opt, min, 1-r.SAct
+		}
+	}
+	if all || c.IsSet("days") {
+		days, err := parseRuleDays(c.String("days"))
+		if err != nil {
+			return err
+		}
+		r.WDay = days
+		r.Repeat = 1
+	}
+	// the end action undoes the start action, keep it that way when only --action changes
+	if r.EtimeOpt != -1 {
+		r.EAct = 1 - r.SAct
+	}
+	return nil
+}
+
+func parseRuleTime(s string) (int8, uint16, error) {
+	switch s {
+	case "sunrise":
+		return 1, 0, nil
+	case "sunset":
+		return 2, 0, nil
+	}
+
+	hm := strings.Split(s, ":")
+	if len(hm) != 2 {
+		return 0, 0, fmt.Errorf("time must be HH:MM, sunrise, or sunset: %s", s)
+	}
+	h, err := strconv.Atoi(hm[0])
+	if err != nil || h < 0 || h > 23 {
+		return 0, 0, fmt.Errorf("bad hour: %s", s)
+	}
+	m, err := strconv.Atoi(hm[1])
+	if err != nil || m < 0 || m > 59 {
+		return 0, 0, fmt.Errorf("bad minute: %s", s)
+	}
+	return 0, uint16(h*60 + m), nil
+}
+
+func parseRuleDays(s string) ([]uint8, error) {
+	switch s {
+	case "daily":
+		return []uint8{1, 1, 1, 1, 1, 1, 1}, nil
+	case "weekdays":
+		return []uint8{0, 1, 1, 1, 1, 1, 0}, nil
+	case "weekends":
+		return []uint8{1, 0, 0, 0, 0, 0, 1}, nil
+	}
+
+	names := []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+	days := make([]uint8, 7)
+	for _, d := range strings.Split(s, ",") {
+		found := false
+		for i, n := range names {
+			if strings.ToLower(strings.TrimSpace(d)) == n {
+				days[i] = 1
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown day: %s", d)
+		}
+	}
+	return days, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/cloudkucooland/toofar/kasasim"
+
+	"github.com/urfave/cli/v2"
+)
+
+func kasaSimCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "kasa-sim",
+		Usage: "simulate Kasa devices for testing without real hardware",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{Name: "model", Value: cli.NewStringSlice("HS103"), Usage: "model to simulate, repeat for more devices: " + strings.Join(kasasim.ModelNames(), ", ")},
+			&cli.StringFlag{Name: "ip", Value: "127.0.0.1", Usage: "address of the first device, each additional device gets the next address"},
+			&cli.IntFlag{Name: "port", Value: 9999, Usage: "port every device listens on"},
+			&cli.StringFlag{Name: "alias", Value: "Sim", Usage: "prefix for the device names"},
+			&cli.BoolFlag{Name: "klap", Usage: "answer KLAP (HTTP) on the port instead of the XOR protocol, as newer firmware does"},
+			&cli.StringFlag{Name: "username", Usage: "KLAP account, unset for a device which was never bound"},
+			&cli.StringFlag{Name: "password", Usage: "KLAP password"},
+		},
+		Action: func(c *cli.Context) error {
+			ip := net.ParseIP(c.String("ip")).To4()
+			if ip == nil {
+				return fmt.Errorf("not an IPv4 address: %s", c.String("ip"))
+			}
+
+			var sims []*kasasim.Device
+			defer func() {
+				for _, d := range sims {
+					d.Close()
+				}
+			}()
+
+			for i, model := range c.StringSlice("model") {
+				d, err := kasasim.New(model, fmt.Sprintf("%s %s %d", c.String("alias"), model, i+1))
+				if err != nil {
+					return err
+				}
+				// real devices each have their own address, on linux all of 127.0.0.0/8 is local
+				addr := net.JoinHostPort(net.IPv4(ip[0], ip[1], ip[2], ip[3]+byte(i)).String(), strconv.Itoa(c.Int("port")))
+				if c.Bool("klap") {
+					err = d.ListenKLAP(addr, 2, c.String("username"), c.String("password"))
+				} else {
+					err = d.Listen(addr)
+				}
+				if err != nil {
+					return err
+				}
+				sims = append(sims, d)
+				fmt.Fprintln(os.Stdout, d.String())
+			}
+
+			sigch := make(chan os.Signal, 1)
+			signal.Notify(sigch, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
+			<-sigch
+			return nil
+		},
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cloudkucooland/toofar/config"
+	"github.com/cloudkucooland/toofar/konnected"
+
+	"github.com/urfave/cli/v2"
+)
+
+func konnectedCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "konnected",
+		Usage: "manage Konnected boards directly",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "provision",
+				Usage: "push the settings from the accessory configs to the boards",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "name", Usage: "accessory name, unset for every board"},
+				},
+				Action: func(c *cli.Context) error {
+					conf := readConfig(c.String("dir"), c.String("config"))
+					// the konnected helpers read the HTTP address and timeouts from the running config
+					config.Set(conf)
+
+					found := false
+					for _, a := range readAccessories(conf.ConfigDir) {
+						if a.Platform != "Konnected" || (c.String("name") != "" && a.Name != c.String("name")) {
+							continue
+						}
+						found = true
+						if err := konnected.Provision(a); err != nil {
+							fmt.Fprintf(os.Stdout, "%s: %s\n", a.Name, err.Error())
+							continue
+						}
+						fmt.Fprintf(os.Stdout, "%s: provisioned\n", a.Name)
+					}
+					if !found {
+						return fmt.Errorf("no Konnected accessories found")
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
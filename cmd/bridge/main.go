@@ -45,50 +45,23 @@ func main() {
 				Destination: &debug,
 			},
 		},
+		Commands: []*cli.Command{
+			kasaCommand(),
+			kasaSimCommand(),
+			konnectedCommand(),
+		},
 		Action: func(c *cli.Context) error {
 			if debug {
 				log.Debug.Enable()
 			}
 
-			fulldir, err := filepath.Abs(dir)
-			if err != nil {
-				log.Info.Panic("unable to get config directory", dir)
-			}
-			cfd := filepath.Join(fulldir, file)
-			confFile, err := os.Open(cfd)
-			if err != nil {
-				log.Info.Panic("unable to open config: ", cfd)
-			}
-			raw, err := ioutil.ReadAll(confFile)
-			if err != nil {
-				log.Info.Panic(err)
-			}
-			confFile.Close()
-
-			var conf config.Config
-			err = json.Unmarshal(raw, &conf)
-			if err != nil {
-				log.Info.Panic(err, string(raw))
-			}
-
-			conf.ConfigDir = fulldir
-			conf.ConfigFile = cfd
+			conf := readConfig(dir, file)
 
 			// spin up platforms to listen to devices
-			toofar.BootstrapPlatforms(&conf)
+			toofar.BootstrapPlatforms(conf)
 
 			// load accessory configs
-			var accdir = filepath.Join(fulldir, "accessories")
-			files, err := ioutil.ReadDir(accdir)
-			if err != nil {
-				log.Info.Panic(err)
-			}
-			for _, f := range files {
-				acc, err := fileToAccessory(filepath.Join(accdir, f.Name()), f.Name())
-				if err != nil {
-					log.Info.Printf(err.Error())
-					continue
-				}
+			for _, acc := range readAccessories(conf.ConfigDir) {
 				toofar.AddAccessory(acc)
 			}
 
@@ -117,6 +90,54 @@ func main() {
 	}
 }
 
+// readConfig loads the server config, panicing if it cannot
+func readConfig(dir, file string) *config.Config {
+	fulldir, err := filepath.Abs(dir)
+	if err != nil {
+		log.Info.Panic("unable to get config directory", dir)
+	}
+	cfd := filepath.Join(fulldir, file)
+	confFile, err := os.Open(cfd)
+	if err != nil {
+		log.Info.Panic("unable to open config: ", cfd)
+	}
+	raw, err := ioutil.ReadAll(confFile)
+	if err != nil {
+		log.Info.Panic(err)
+	}
+	confFile.Close()
+
+	var conf config.Config
+	err = json.Unmarshal(raw, &conf)
+	if err != nil {
+		log.Info.Panic(err, string(raw))
+	}
+
+	conf.ConfigDir = fulldir
+	conf.ConfigFile = cfd
+	return &conf
+}
+
+// readAccessories loads every accessory config in the accessories directory
+func readAccessories(fulldir string) []*accessory.TFAccessory {
+	var accdir = filepath.Join(fulldir, "accessories")
+	files, err := ioutil.ReadDir(accdir)
+	if err != nil {
+		log.Info.Panic(err)
+	}
+
+	var accs []*accessory.TFAccessory
+	for _, f := range files {
+		acc, err := fileToAccessory(filepath.Join(accdir, f.Name()), f.Name())
+		if err != nil {
+			log.Info.Printf(err.Error())
+			continue
+		}
+		accs = append(accs, acc)
+	}
+	return accs
+}
+
 func fileToAccessory(file string, name string) (*accessory.TFAccessory, error) {
 	f, err := os.Open(file)
 	if err != nil {
@@ -136,6 +157,7 @@ func fileToAccessory(file string, name string) (*accessory.TFAccessory, error) {
 	}
 
 	acc.Name = name[:strings.LastIndex(name, ".")]
+	acc.ConfigFile = file
 	// log.Info.Printf("%+v", acc)
 	return &acc, nil
 }
@@ -6,20 +6,28 @@ import (
 
 // Config is the global daemon configuration...
 type Config struct {
-	ConfigDir         string    // passed in from CLI
-	ConfigFile        string    // server.json
-	HTTPAddress       string    // net.Dial address format, :port is good enough
-	Name              string    // what this bridge shows as
-	ID                string    // displayed serial number -- if you run multiple instances, make sure each has a distinct ID
-	HCConfig          hc.Config // base HomeControl configuration
-	Discover          bool      // run Kasa, Konnected, & Shelly auto-discovery (does not work properly yet, do not enable)
-	KasaPullRate      uint16    // (seconds) how frequently to pull Kasa devices -- 0 to disable
-	KasaBroadcasts    uint8     // number of UDP broadcast packets to send - 1 is usually enough -- (unset/0/1 sends 1 packet)
-	KasaTimeout       uint8     // how long to wait for direct (TCP) pulls
-	ShellyPullRate    uint16    // 0 to disable pulling
-	ShellyTimeout     uint8     // how long to wait for direct pulls
-	KonnectedTimeout  uint8     /// how long to wait for direct pulls
-	KonnectedPullRate uint16    /// how frequently to pull , 0 to disable
+	ConfigDir              string    // passed in from CLI
+	ConfigFile             string    // server.json
+	HTTPAddress            string    // net.Dial address format, :port is good enough
+	Name                   string    // what this bridge shows as
+	ID                     string    // displayed serial number -- if you run multiple instances, make sure each has a distinct ID
+	HCConfig               hc.Config // base HomeControl configuration
+	Discover               bool      // automatically add discovered Kasa, Konnected, & Shelly devices (does not work properly yet, do not enable)
+	KasaPullRate           uint16    // (seconds) how frequently to pull Kasa devices -- 0 to disable
+	KasaBroadcasts         uint8     // number of UDP broadcast packets to send - 1 is usually enough -- (unset/0/1 sends 1 packet)
+	KasaTimeout            uint8     // how long to wait for direct (TCP) pulls
+	KasaRetries            uint8     // how many times to retry commands the device does not acknowledge (unset/0 retries twice)
+	KasaInterfaces         []string  // network interfaces to broadcast on -- unset for all
+	KasaSubnets            []string  // CIDRs to search one address at a time, for VLANs broadcasts do not reach
+	KasaDiscoveryRate      uint16    // (seconds) how frequently to look for new Kasa devices -- 0 for only at startup
+	ShellyPullRate         uint16    // 0 to disable pulling
+	ShellyTimeout          uint8     // how long to wait for direct pulls
+	KonnectedTimeout       uint8     /// how long to wait for direct pulls
+	KonnectedPullRate      uint16    /// how frequently to pull , 0 to disable
+	KonnectedProvision     bool      // push each board's settings from its accessory config at startup
+	KonnectedDiscoveryRate uint16    // (seconds) how frequently to look for new or moved boards -- 0 for only at startup
+	KonnectedSSDPAddress   string    // where to send SSDP searches -- unset for the standard multicast group
+	KonnectedOfflineAfter  uint16    // (seconds) how long a board can go unheard before it is marked offline -- unset for 300
 }
 
 var runningConfig *Config
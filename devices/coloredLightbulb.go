@@ -0,0 +1,39 @@
+package devices
+
+import (
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/service"
+)
+
+// ColoredLightbulb is hc's colored lightbulb with color temperature added
+type ColoredLightbulb struct {
+	*accessory.Accessory
+	Lightbulb *ColoredLightbulbSvc
+}
+
+func NewColoredLightbulb(info accessory.Info) *ColoredLightbulb {
+	acc := ColoredLightbulb{}
+	acc.Accessory = accessory.New(info, accessory.TypeLightbulb)
+	acc.Lightbulb = NewColoredLightbulbSvc()
+
+	acc.AddService(acc.Lightbulb.Service)
+
+	return &acc
+}
+
+type ColoredLightbulbSvc struct {
+	*service.ColoredLightbulb
+
+	ColorTemperature *characteristic.ColorTemperature
+}
+
+func NewColoredLightbulbSvc() *ColoredLightbulbSvc {
+	svc := ColoredLightbulbSvc{}
+	svc.ColoredLightbulb = service.NewColoredLightbulb()
+
+	svc.ColorTemperature = characteristic.NewColorTemperature()
+	svc.AddCharacteristic(svc.ColorTemperature.Characteristic)
+
+	return &svc
+}
@@ -0,0 +1,45 @@
+package devices
+
+import (
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/service"
+)
+
+// DimmableLightbulb is a white bulb with a fixed color temperature, Brightness is nil if it does not dim either
+type DimmableLightbulb struct {
+	*accessory.Accessory
+	Lightbulb *DimmableLightbulbSvc
+}
+
+func NewDimmableLightbulb(info accessory.Info, dimmable bool) *DimmableLightbulb {
+	acc := DimmableLightbulb{}
+	acc.Accessory = accessory.New(info, accessory.TypeLightbulb)
+	acc.Lightbulb = NewDimmableLightbulbSvc(dimmable)
+
+	acc.AddService(acc.Lightbulb.Service)
+
+	return &acc
+}
+
+type DimmableLightbulbSvc struct {
+	*service.Service
+
+	On         *characteristic.On
+	Brightness *characteristic.Brightness
+}
+
+func NewDimmableLightbulbSvc(dimmable bool) *DimmableLightbulbSvc {
+	svc := DimmableLightbulbSvc{}
+	svc.Service = service.New(service.TypeLightbulb)
+
+	svc.On = characteristic.NewOn()
+	svc.AddCharacteristic(svc.On.Characteristic)
+
+	if dimmable {
+		svc.Brightness = characteristic.NewBrightness()
+		svc.AddCharacteristic(svc.Brightness.Characteristic)
+	}
+
+	return &svc
+}
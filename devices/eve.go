@@ -0,0 +1,86 @@
+package devices
+
+import (
+	"github.com/brutella/hc/characteristic"
+)
+
+// Eve's custom energy characteristics
+// the Home app ignores these, but the Eve and Controller apps display them and can automate on them
+const (
+	TypeEveVoltage          = "E863F10A-079E-48FF-8F27-9C2605A29F52"
+	TypeEveTotalConsumption = "E863F10C-079E-48FF-8F27-9C2605A29F52"
+	TypeEvePower            = "E863F10D-079E-48FF-8F27-9C2605A29F52"
+	TypeEveCurrent          = "E863F126-079E-48FF-8F27-9C2605A29F52"
+)
+
+// EveVoltage is the current voltage (V)
+type EveVoltage struct {
+	*characteristic.Float
+}
+
+func NewEveVoltage() *EveVoltage {
+	char := characteristic.NewFloat(TypeEveVoltage)
+	char.Format = characteristic.FormatFloat
+	char.Perms = []string{characteristic.PermRead, characteristic.PermEvents}
+	char.Description = "Volts"
+	char.SetMinValue(0)
+	char.SetMaxValue(300)
+	char.SetStepValue(0.1)
+	char.SetValue(0)
+
+	return &EveVoltage{char}
+}
+
+// EveCurrent is the current draw (A)
+type EveCurrent struct {
+	*characteristic.Float
+}
+
+func NewEveCurrent() *EveCurrent {
+	char := characteristic.NewFloat(TypeEveCurrent)
+	char.Format = characteristic.FormatFloat
+	char.Perms = []string{characteristic.PermRead, characteristic.PermEvents}
+	char.Description = "Amps"
+	char.SetMinValue(0)
+	char.SetMaxValue(20)
+	char.SetStepValue(0.01)
+	char.SetValue(0)
+
+	return &EveCurrent{char}
+}
+
+// EvePower is the current power consumption (W)
+type EvePower struct {
+	*characteristic.Float
+}
+
+func NewEvePower() *EvePower {
+	char := characteristic.NewFloat(TypeEvePower)
+	char.Format = characteristic.FormatFloat
+	char.Perms = []string{characteristic.PermRead, characteristic.PermEvents}
+	char.Description = "Watts"
+	char.SetMinValue(0)
+	char.SetMaxValue(4000)
+	char.SetStepValue(0.1)
+	char.SetValue(0)
+
+	return &EvePower{char}
+}
+
+// EveTotalConsumption is the cumulative consumption (kWh) as reported by the device
+type EveTotalConsumption struct {
+	*characteristic.Float
+}
+
+func NewEveTotalConsumption() *EveTotalConsumption {
+	char := characteristic.NewFloat(TypeEveTotalConsumption)
+	char.Format = characteristic.FormatFloat
+	char.Perms = []string{characteristic.PermRead, characteristic.PermEvents}
+	char.Description = "kWh"
+	char.SetMinValue(0)
+	char.SetMaxValue(1000000)
+	char.SetStepValue(0.001)
+	char.SetValue(0)
+
+	return &EveTotalConsumption{char}
+}
@@ -29,6 +29,14 @@ type HS220Svc struct {
 	ProgramMode       *characteristic.ProgramMode
 	SetDuration       *characteristic.SetDuration
 	RemainingDuration *characteristic.RemainingDuration
+
+	// dimmer settings
+	Transition    *TooFarDuration
+	FadeOnTime    *TooFarDuration
+	FadeOffTime   *TooFarDuration
+	GentleOnTime  *TooFarDuration
+	GentleOffTime *TooFarDuration
+	DoubleClick   *TooFarDoubleClick
 }
 
 func NewHS220Svc() *HS220Svc {
@@ -52,5 +60,23 @@ func NewHS220Svc() *HS220Svc {
 	svc.AddCharacteristic(svc.RemainingDuration.Characteristic)
 	svc.RemainingDuration.SetValue(0)
 
+	svc.Transition = NewTooFarTransition()
+	svc.AddCharacteristic(svc.Transition.Characteristic)
+
+	svc.FadeOnTime = NewTooFarFadeOnTime()
+	svc.AddCharacteristic(svc.FadeOnTime.Characteristic)
+
+	svc.FadeOffTime = NewTooFarFadeOffTime()
+	svc.AddCharacteristic(svc.FadeOffTime.Characteristic)
+
+	svc.GentleOnTime = NewTooFarGentleOnTime()
+	svc.AddCharacteristic(svc.GentleOnTime.Characteristic)
+
+	svc.GentleOffTime = NewTooFarGentleOffTime()
+	svc.AddCharacteristic(svc.GentleOffTime.Characteristic)
+
+	svc.DoubleClick = NewTooFarDoubleClick()
+	svc.AddCharacteristic(svc.DoubleClick.Characteristic)
+
 	return &svc
 }
@@ -0,0 +1,76 @@
+package devices
+
+import (
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/service"
+)
+
+// KasaExtras are the optional device-management services which can be added to any Kasa accessory
+// each is nil unless enabled in the accessory config
+type KasaExtras struct {
+	LED    *KasaExtraSwitch // on when the status LED is lit
+	Reboot *KasaExtraSwitch // turns itself back off after rebooting the device
+	Cloud  *KasaCloudSensor // open when the device is bound to a cloud account
+}
+
+func NewKasaExtras(acc *accessory.Accessory, led, reboot, cloud bool) *KasaExtras {
+	e := KasaExtras{}
+
+	if led {
+		e.LED = NewKasaExtraSwitch("Status LED")
+		acc.AddService(e.LED.Service)
+	}
+	if reboot {
+		e.Reboot = NewKasaExtraSwitch("Reboot")
+		acc.AddService(e.Reboot.Service)
+	}
+	if cloud {
+		e.Cloud = NewKasaCloudSensor("Cloud Binding")
+		acc.AddService(e.Cloud.Service)
+	}
+
+	return &e
+}
+
+type KasaExtraSwitch struct {
+	*service.Service
+
+	On   *characteristic.On
+	Name *characteristic.Name
+}
+
+func NewKasaExtraSwitch(name string) *KasaExtraSwitch {
+	svc := KasaExtraSwitch{}
+	svc.Service = service.New(service.TypeSwitch)
+
+	svc.On = characteristic.NewOn()
+	svc.AddCharacteristic(svc.On.Characteristic)
+
+	svc.Name = characteristic.NewName()
+	svc.Name.SetValue(name)
+	svc.AddCharacteristic(svc.Name.Characteristic)
+
+	return &svc
+}
+
+type KasaCloudSensor struct {
+	*service.Service
+
+	ContactSensorState *characteristic.ContactSensorState
+	Name               *characteristic.Name
+}
+
+func NewKasaCloudSensor(name string) *KasaCloudSensor {
+	svc := KasaCloudSensor{}
+	svc.Service = service.New(service.TypeContactSensor)
+
+	svc.ContactSensorState = characteristic.NewContactSensorState()
+	svc.AddCharacteristic(svc.ContactSensorState.Characteristic)
+
+	svc.Name = characteristic.NewName()
+	svc.Name.SetValue(name)
+	svc.AddCharacteristic(svc.Name.Characteristic)
+
+	return &svc
+}
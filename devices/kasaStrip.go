@@ -0,0 +1,103 @@
+package devices
+
+import (
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/service"
+)
+
+// KasaStrip is any Kasa device with child outlets: KP303, KP400, KP200, HS300...
+type KasaStrip struct {
+	*accessory.Accessory
+	Outlets []*KasaStripOutlet
+
+	// true if the children do energy monitoring (HS300)
+	Emeter bool
+}
+
+func NewKasaStrip(info accessory.Info, children int, emeter bool) *KasaStrip {
+	acc := KasaStrip{}
+	acc.Accessory = accessory.New(info, accessory.TypeOutlet)
+	acc.Emeter = emeter
+
+	acc.Outlets = make([]*KasaStripOutlet, children)
+	for i := 0; i < children; i++ {
+		acc.Outlets[i] = NewKasaStripOutlet(emeter)
+		acc.AddService(acc.Outlets[i].Service)
+	}
+
+	return &acc
+}
+
+type KasaStripOutlet struct {
+	*service.Service
+
+	On          *characteristic.On
+	OutletInUse *characteristic.OutletInUse
+	Name        *characteristic.Name
+
+	ProgramMode       *characteristic.ProgramMode
+	SetDuration       *characteristic.SetDuration
+	RemainingDuration *characteristic.RemainingDuration
+
+	// nil unless the strip does energy monitoring
+	Volts *EveVoltage
+	Amps  *EveCurrent
+	Watts *EvePower
+	KWH   *EveTotalConsumption
+
+	// not displayed in HC
+	ChildID string
+	OnTime  int
+}
+
+func NewKasaStripOutlet(emeter bool) *KasaStripOutlet {
+	svc := KasaStripOutlet{}
+	svc.Service = service.New(service.TypeOutlet)
+
+	svc.On = characteristic.NewOn()
+	svc.AddCharacteristic(svc.On.Characteristic)
+
+	svc.OutletInUse = characteristic.NewOutletInUse()
+	svc.AddCharacteristic(svc.OutletInUse.Characteristic)
+
+	svc.Name = characteristic.NewName()
+	svc.AddCharacteristic(svc.Name.Characteristic)
+
+	svc.ProgramMode = characteristic.NewProgramMode()
+	svc.AddCharacteristic(svc.ProgramMode.Characteristic)
+	svc.ProgramMode.SetValue(characteristic.ProgramModeNoProgramScheduled)
+
+	svc.SetDuration = characteristic.NewSetDuration()
+	svc.AddCharacteristic(svc.SetDuration.Characteristic)
+
+	svc.RemainingDuration = characteristic.NewRemainingDuration()
+	svc.AddCharacteristic(svc.RemainingDuration.Characteristic)
+	svc.RemainingDuration.SetValue(0)
+
+	if emeter {
+		svc.Volts = NewEveVoltage()
+		svc.AddCharacteristic(svc.Volts.Characteristic)
+
+		svc.Amps = NewEveCurrent()
+		svc.AddCharacteristic(svc.Amps.Characteristic)
+
+		svc.Watts = NewEvePower()
+		svc.AddCharacteristic(svc.Watts.Characteristic)
+
+		svc.KWH = NewEveTotalConsumption()
+		svc.AddCharacteristic(svc.KWH.Characteristic)
+	}
+
+	return &svc
+}
+
+// Child looks up an outlet by the child ID the device reports
+func (k *KasaStrip) Child(id string) *KasaStripOutlet {
+	for _, o := range k.Outlets {
+		if o.ChildID == id {
+			return o
+		}
+	}
+	return nil
+}
@@ -10,7 +10,14 @@ type Konnected struct {
 	*accessory.Accessory
 
 	SecuritySystem *KonnectedSvc
-	Pins           map[uint8]interface{}
+	Pins           map[uint8]interface{} // Konnected Pro zones are given pin numbers
+
+	// not displayed in HC
+	Pro bool // an ESP32 board, which uses zones instead of pins
+
+	// keyed by pin and probe address ("6/28ff..."), DHTs have no address ("7/")
+	Temperatures map[string]*KonnectedTemperatureSensor
+	Humidities   map[uint8]*KonnectedHumiditySensor
 }
 
 func NewKonnected(info accessory.Info) *Konnected {
@@ -26,6 +33,8 @@ func NewKonnected(info accessory.Info) *Konnected {
 	acc.SecuritySystem.AddCharacteristic(alarmType.Characteristic)
 
 	acc.Pins = make(map[uint8]interface{})
+	acc.Temperatures = make(map[string]*KonnectedTemperatureSensor)
+	acc.Humidities = make(map[uint8]*KonnectedHumiditySensor)
 
 	return &acc
 }
@@ -34,6 +43,8 @@ type KonnectedSvc struct {
 	*service.Service
 	SecuritySystemCurrentState *characteristic.SecuritySystemCurrentState
 	SecuritySystemTargetState  *characteristic.SecuritySystemTargetState
+	StatusFault                *characteristic.StatusFault
+	StatusTampered             *characteristic.StatusTampered
 }
 
 func NewKonnectedSvc() *KonnectedSvc {
@@ -46,6 +57,12 @@ func NewKonnectedSvc() *KonnectedSvc {
 	svc.SecuritySystemTargetState = characteristic.NewSecuritySystemTargetState()
 	svc.AddCharacteristic(svc.SecuritySystemTargetState.Characteristic)
 
+	svc.StatusFault = characteristic.NewStatusFault()
+	svc.AddCharacteristic(svc.StatusFault.Characteristic)
+
+	svc.StatusTampered = characteristic.NewStatusTampered()
+	svc.AddCharacteristic(svc.StatusTampered.Characteristic)
+
 	return &svc
 }
 
@@ -53,6 +70,8 @@ type KonnectedContactSensor struct {
 	*service.Service
 
 	ContactSensorState *characteristic.ContactSensorState
+	StatusFault        *characteristic.StatusFault
+	StatusTampered     *characteristic.StatusTampered
 	Name               *characteristic.Name
 }
 
@@ -63,6 +82,12 @@ func NewKonnectedContactSensor(name string) *KonnectedContactSensor {
 	svc.ContactSensorState = characteristic.NewContactSensorState()
 	svc.AddCharacteristic(svc.ContactSensorState.Characteristic)
 
+	svc.StatusFault = characteristic.NewStatusFault()
+	svc.AddCharacteristic(svc.StatusFault.Characteristic)
+
+	svc.StatusTampered = characteristic.NewStatusTampered()
+	svc.AddCharacteristic(svc.StatusTampered.Characteristic)
+
 	svc.Name = characteristic.NewName()
 	svc.Name.SetValue(name)
 	svc.AddCharacteristic(svc.Name.Characteristic)
@@ -74,6 +99,8 @@ type KonnectedMotionSensor struct {
 	*service.Service
 
 	MotionDetected *characteristic.MotionDetected
+	StatusFault    *characteristic.StatusFault
+	StatusTampered *characteristic.StatusTampered
 	Name           *characteristic.Name
 }
 
@@ -84,6 +111,12 @@ func NewKonnectedMotionSensor(name string) *KonnectedMotionSensor {
 	svc.MotionDetected = characteristic.NewMotionDetected()
 	svc.AddCharacteristic(svc.MotionDetected.Characteristic)
 
+	svc.StatusFault = characteristic.NewStatusFault()
+	svc.AddCharacteristic(svc.StatusFault.Characteristic)
+
+	svc.StatusTampered = characteristic.NewStatusTampered()
+	svc.AddCharacteristic(svc.StatusTampered.Characteristic)
+
 	svc.Name = characteristic.NewName()
 	svc.Name.SetValue(name)
 	svc.AddCharacteristic(svc.Name.Characteristic)
@@ -117,3 +150,146 @@ func NewKonnectedBuzzer(name string) *KonnectedBuzzer {
 
 	return &svc
 }
+
+// KonnectedSwitch is an actuator pin: a relay, siren, or strobe
+type KonnectedSwitch struct {
+	*service.Service
+
+	On   *characteristic.On
+	Name *characteristic.Name
+}
+
+func NewKonnectedSwitch(name string) *KonnectedSwitch {
+	svc := KonnectedSwitch{}
+	svc.Service = service.New(service.TypeSwitch)
+
+	svc.On = characteristic.NewOn()
+	svc.AddCharacteristic(svc.On.Characteristic)
+
+	svc.Name = characteristic.NewName()
+	svc.Name.SetValue(name)
+	svc.AddCharacteristic(svc.Name.Characteristic)
+
+	return &svc
+}
+
+// KonnectedGarageDoor pulses an actuator pin to operate the opener, the door position comes from a sensor pin
+type KonnectedGarageDoor struct {
+	*service.Service
+
+	CurrentDoorState    *characteristic.CurrentDoorState
+	TargetDoorState     *characteristic.TargetDoorState
+	ObstructionDetected *characteristic.ObstructionDetected
+	Name                *characteristic.Name
+
+	// not displayed in HC
+	SensorPin uint8
+}
+
+func NewKonnectedGarageDoor(name string) *KonnectedGarageDoor {
+	svc := KonnectedGarageDoor{}
+	svc.Service = service.New(service.TypeGarageDoorOpener)
+
+	svc.CurrentDoorState = characteristic.NewCurrentDoorState()
+	svc.CurrentDoorState.SetValue(characteristic.CurrentDoorStateClosed)
+	svc.AddCharacteristic(svc.CurrentDoorState.Characteristic)
+
+	svc.TargetDoorState = characteristic.NewTargetDoorState()
+	svc.TargetDoorState.SetValue(characteristic.TargetDoorStateClosed)
+	svc.AddCharacteristic(svc.TargetDoorState.Characteristic)
+
+	svc.ObstructionDetected = characteristic.NewObstructionDetected()
+	svc.AddCharacteristic(svc.ObstructionDetected.Characteristic)
+
+	svc.Name = characteristic.NewName()
+	svc.Name.SetValue(name)
+	svc.AddCharacteristic(svc.Name.Characteristic)
+
+	return &svc
+}
+
+// KonnectedValve is an actuator pin driving a water valve
+type KonnectedValve struct {
+	*service.Service
+
+	Active    *characteristic.Active
+	InUse     *characteristic.InUse
+	ValveType *characteristic.ValveType
+	Name      *characteristic.Name
+}
+
+func NewKonnectedValve(name string) *KonnectedValve {
+	svc := KonnectedValve{}
+	svc.Service = service.New(service.TypeValve)
+
+	svc.Active = characteristic.NewActive()
+	svc.Active.SetValue(characteristic.ActiveInactive)
+	svc.AddCharacteristic(svc.Active.Characteristic)
+
+	svc.InUse = characteristic.NewInUse()
+	svc.InUse.SetValue(characteristic.InUseNotInUse)
+	svc.AddCharacteristic(svc.InUse.Characteristic)
+
+	svc.ValveType = characteristic.NewValveType()
+	svc.ValveType.SetValue(characteristic.ValveTypeGenericValve)
+	svc.AddCharacteristic(svc.ValveType.Characteristic)
+
+	svc.Name = characteristic.NewName()
+	svc.Name.SetValue(name)
+	svc.AddCharacteristic(svc.Name.Characteristic)
+
+	return &svc
+}
+
+// KonnectedTemperatureSensor is a DS18B20 probe or the temperature half of a DHT
+type KonnectedTemperatureSensor struct {
+	*service.Service
+
+	CurrentTemperature *characteristic.CurrentTemperature
+	StatusFault        *characteristic.StatusFault
+	Name               *characteristic.Name
+}
+
+func NewKonnectedTemperatureSensor(name string) *KonnectedTemperatureSensor {
+	svc := KonnectedTemperatureSensor{}
+	svc.Service = service.New(service.TypeTemperatureSensor)
+
+	svc.CurrentTemperature = characteristic.NewCurrentTemperature()
+	svc.CurrentTemperature.SetMinValue(-55) // the lowest a DS18B20 reads, attics and crawlspaces freeze
+	svc.AddCharacteristic(svc.CurrentTemperature.Characteristic)
+
+	svc.StatusFault = characteristic.NewStatusFault()
+	svc.AddCharacteristic(svc.StatusFault.Characteristic)
+
+	svc.Name = characteristic.NewName()
+	svc.Name.SetValue(name)
+	svc.AddCharacteristic(svc.Name.Characteristic)
+
+	return &svc
+}
+
+// KonnectedHumiditySensor is the humidity half of a DHT
+type KonnectedHumiditySensor struct {
+	*service.Service
+
+	CurrentRelativeHumidity *characteristic.CurrentRelativeHumidity
+	StatusFault             *characteristic.StatusFault
+	Name                    *characteristic.Name
+}
+
+func NewKonnectedHumiditySensor(name string) *KonnectedHumiditySensor {
+	svc := KonnectedHumiditySensor{}
+	svc.Service = service.New(service.TypeHumiditySensor)
+
+	svc.CurrentRelativeHumidity = characteristic.NewCurrentRelativeHumidity()
+	svc.AddCharacteristic(svc.CurrentRelativeHumidity.Characteristic)
+
+	svc.StatusFault = characteristic.NewStatusFault()
+	svc.AddCharacteristic(svc.StatusFault.Characteristic)
+
+	svc.Name = characteristic.NewName()
+	svc.Name.SetValue(name)
+	svc.AddCharacteristic(svc.Name.Characteristic)
+
+	return &svc
+}
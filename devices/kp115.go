@@ -31,6 +31,11 @@ type KP115Svc struct {
 	ProgramMode       *characteristic.ProgramMode
 	SetDuration       *characteristic.SetDuration
 	RemainingDuration *characteristic.RemainingDuration
+
+	Volts *EveVoltage
+	Amps  *EveCurrent
+	Watts *EvePower
+	KWH   *EveTotalConsumption
 }
 
 func NewKP115Svc() *KP115Svc {
@@ -54,5 +59,17 @@ func NewKP115Svc() *KP115Svc {
 	svc.AddCharacteristic(svc.RemainingDuration.Characteristic)
 	svc.RemainingDuration.SetValue(0)
 
+	svc.Volts = NewEveVoltage()
+	svc.AddCharacteristic(svc.Volts.Characteristic)
+
+	svc.Amps = NewEveCurrent()
+	svc.AddCharacteristic(svc.Amps.Characteristic)
+
+	svc.Watts = NewEvePower()
+	svc.AddCharacteristic(svc.Watts.Characteristic)
+
+	svc.KWH = NewEveTotalConsumption()
+	svc.AddCharacteristic(svc.KWH.Characteristic)
+
 	return &svc
 }
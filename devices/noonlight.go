@@ -2,15 +2,76 @@ package devices
 
 import (
 	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/service"
 )
 
 // a system might have several chips
 type Noonlight struct {
 	*accessory.Accessory
+
+	Dispatch *NoonlightDispatch
+	Cancel   *NoonlightCancel
 }
 
 func NewNoonlight(info accessory.Info) *Noonlight {
 	acc := Noonlight{}
 	acc.Accessory = accessory.New(info, accessory.TypeSensor)
+
+	acc.Dispatch = NewNoonlightDispatch("Dispatch Active")
+	acc.AddService(acc.Dispatch.Service)
+
+	acc.Cancel = NewNoonlightCancel("Cancel Dispatch")
+	acc.AddService(acc.Cancel.Service)
+
 	return &acc
 }
+
+// NoonlightDispatch is open while Noonlight is handling an alarm
+type NoonlightDispatch struct {
+	*service.Service
+
+	ContactSensorState *characteristic.ContactSensorState
+	DispatchState      *TooFarDispatchState
+	Name               *characteristic.Name
+}
+
+func NewNoonlightDispatch(name string) *NoonlightDispatch {
+	svc := NoonlightDispatch{}
+	svc.Service = service.New(service.TypeContactSensor)
+
+	svc.ContactSensorState = characteristic.NewContactSensorState()
+	svc.ContactSensorState.SetValue(characteristic.ContactSensorStateContactDetected)
+	svc.AddCharacteristic(svc.ContactSensorState.Characteristic)
+
+	svc.DispatchState = NewTooFarDispatchState()
+	svc.AddCharacteristic(svc.DispatchState.Characteristic)
+
+	svc.Name = characteristic.NewName()
+	svc.Name.SetValue(name)
+	svc.AddCharacteristic(svc.Name.Characteristic)
+
+	return &svc
+}
+
+// NoonlightCancel turns itself back off after cancelling the alarm
+type NoonlightCancel struct {
+	*service.Service
+
+	On   *characteristic.On
+	Name *characteristic.Name
+}
+
+func NewNoonlightCancel(name string) *NoonlightCancel {
+	svc := NoonlightCancel{}
+	svc.Service = service.New(service.TypeSwitch)
+
+	svc.On = characteristic.NewOn()
+	svc.AddCharacteristic(svc.On.Characteristic)
+
+	svc.Name = characteristic.NewName()
+	svc.Name.SetValue(name)
+	svc.AddCharacteristic(svc.Name.Characteristic)
+
+	return &svc
+}
@@ -0,0 +1,112 @@
+package devices
+
+import (
+	"github.com/brutella/hc/characteristic"
+)
+
+// TooFar's own custom characteristics, for settings HomeKit has no type for
+// the Home app ignores these, but the Eve and Controller apps display them and scenes/automations can set them
+// the UUIDs end in "TOOFAR" in hex
+const (
+	TypeTooFarTransition    = "00000001-0000-1000-8000-544F4F464152"
+	TypeTooFarFadeOnTime    = "00000002-0000-1000-8000-544F4F464152"
+	TypeTooFarFadeOffTime   = "00000003-0000-1000-8000-544F4F464152"
+	TypeTooFarGentleOnTime  = "00000004-0000-1000-8000-544F4F464152"
+	TypeTooFarGentleOffTime = "00000005-0000-1000-8000-544F4F464152"
+	TypeTooFarDoubleClick   = "00000006-0000-1000-8000-544F4F464152"
+	TypeTooFarDispatchState = "00000007-0000-1000-8000-544F4F464152"
+)
+
+// the double-click actions dimmers support
+const (
+	DoubleClickNone         = 0
+	DoubleClickInstantOnOff = 1
+	DoubleClickGentleOnOff  = 2
+)
+
+// the states of a Noonlight alarm
+const (
+	DispatchNone       = 0
+	DispatchActive     = 1 // Noonlight is calling
+	DispatchCancelled  = 2
+	DispatchDispatched = 3 // responders are on the way
+)
+
+// TooFarDuration is a time in milliseconds
+type TooFarDuration struct {
+	*characteristic.Int
+}
+
+func newTooFarDuration(typ, description string) *TooFarDuration {
+	char := characteristic.NewInt(typ)
+	char.Format = characteristic.FormatUInt32
+	char.Perms = []string{characteristic.PermRead, characteristic.PermWrite, characteristic.PermEvents}
+	char.Description = description
+	char.SetMinValue(0)
+	char.SetMaxValue(7200000) // two hours
+	char.SetStepValue(100)
+	char.SetValue(0)
+
+	return &TooFarDuration{char}
+}
+
+// NewTooFarTransition is how long brightness and on/off changes made from HomeKit take
+func NewTooFarTransition() *TooFarDuration {
+	return newTooFarDuration(TypeTooFarTransition, "Transition (ms)")
+}
+
+// NewTooFarFadeOnTime is how long the light takes to come on from the button
+func NewTooFarFadeOnTime() *TooFarDuration {
+	return newTooFarDuration(TypeTooFarFadeOnTime, "Fade On Time (ms)")
+}
+
+// NewTooFarFadeOffTime is how long the light takes to go off from the button
+func NewTooFarFadeOffTime() *TooFarDuration {
+	return newTooFarDuration(TypeTooFarFadeOffTime, "Fade Off Time (ms)")
+}
+
+// NewTooFarGentleOnTime is how long the light takes to come on from a long press
+func NewTooFarGentleOnTime() *TooFarDuration {
+	return newTooFarDuration(TypeTooFarGentleOnTime, "Gentle On Time (ms)")
+}
+
+// NewTooFarGentleOffTime is how long the light takes to go off from a long press
+func NewTooFarGentleOffTime() *TooFarDuration {
+	return newTooFarDuration(TypeTooFarGentleOffTime, "Gentle Off Time (ms)")
+}
+
+// TooFarDoubleClick is what a double-click on the button does, one of the DoubleClick constants
+type TooFarDoubleClick struct {
+	*characteristic.Int
+}
+
+func NewTooFarDoubleClick() *TooFarDoubleClick {
+	char := characteristic.NewInt(TypeTooFarDoubleClick)
+	char.Format = characteristic.FormatUInt8
+	char.Perms = []string{characteristic.PermRead, characteristic.PermWrite, characteristic.PermEvents}
+	char.Description = "Double Click Action"
+	char.SetMinValue(DoubleClickNone)
+	char.SetMaxValue(DoubleClickGentleOnOff)
+	char.SetStepValue(1)
+	char.SetValue(DoubleClickNone)
+
+	return &TooFarDoubleClick{char}
+}
+
+// TooFarDispatchState is where a Noonlight alarm is, one of the Dispatch constants
+type TooFarDispatchState struct {
+	*characteristic.Int
+}
+
+func NewTooFarDispatchState() *TooFarDispatchState {
+	char := characteristic.NewInt(TypeTooFarDispatchState)
+	char.Format = characteristic.FormatUInt8
+	char.Perms = []string{characteristic.PermRead, characteristic.PermEvents}
+	char.Description = "Dispatch State"
+	char.SetMinValue(DispatchNone)
+	char.SetMaxValue(DispatchDispatched)
+	char.SetStepValue(1)
+	char.SetValue(DispatchNone)
+
+	return &TooFarDispatchState{char}
+}
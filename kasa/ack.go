@@ -0,0 +1,185 @@
+package kasa
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/log"
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/config"
+	"net"
+	"sync"
+	"time"
+)
+
+const ackBackoff = 250 * time.Millisecond
+
+// sendCmdAck sends a command and waits for the device to acknowledge it, retrying with backoff if it does not answer
+// the response is then handled as if it came in on the listener
+func sendCmdAck(a *tfaccessory.TFAccessory, cmd string) error {
+	retries := config.Get().KasaRetries
+	// unset/0 -- use the default of 2
+	if retries == 0 {
+		retries = 2
+	}
+
+	var err error
+	backoff := ackBackoff
+	for i := uint8(0); i <= retries; i++ {
+		if i > 0 {
+			log.Info.Printf("retrying command to [%s]: %s", a.Info.Name, err.Error())
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var res string
+		res, err = transportFor(a).send(cmd)
+		if err != nil {
+			continue
+		}
+		// the device heard us and said no, asking again will not help
+		if err := checkErrCode(res); err != nil {
+			return fmt.Errorf("[%s] rejected command: %s", a.Info.Name, err.Error())
+		}
+		doUDPresponse(ipOf(a), res)
+		return nil
+	}
+	return fmt.Errorf("[%s] did not acknowledge command: %s", a.Info.Name, err.Error())
+}
+
+// checkErrCode looks for a non-zero err_code at the module or method level of a response
+// {"module":{"err_code":-1,"err_msg":"module not support"}} or {"module":{"method":{"err_code":-3,"err_msg":"..."}}}
+func checkErrCode(res string) error {
+	var modules map[string]map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(res), &modules); err != nil {
+		return err
+	}
+
+	type result struct {
+		ErrorCode    int    `json:"err_code"`
+		ErrorMessage string `json:"err_msg"`
+	}
+
+	for module, methods := range modules {
+		if raw, ok := methods["err_code"]; ok {
+			var r result
+			json.Unmarshal(raw, &r.ErrorCode)
+			json.Unmarshal(methods["err_msg"], &r.ErrorMessage)
+			if r.ErrorCode != 0 {
+				return fmt.Errorf("%s: %s (%d)", module, r.ErrorMessage, r.ErrorCode)
+			}
+		}
+		for method, raw := range methods {
+			var r result
+			if err := json.Unmarshal(raw, &r); err != nil {
+				// not an object, e.g. the module level err_code
+				continue
+			}
+			if r.ErrorCode != 0 {
+				return fmt.Errorf("%s.%s: %s (%d)", module, method, r.ErrorMessage, r.ErrorCode)
+			}
+		}
+	}
+	return nil
+}
+
+// onRemoteUpdateOrRevert installs fn as the handler for HomeKit changes to c
+// if the device does not take the change, c goes back to what it showed before so HomeKit shows what the device is actually doing
+func onRemoteUpdateOrRevert(a *tfaccessory.TFAccessory, c *characteristic.Characteristic, fn func(newval interface{}) error) {
+	c.OnValueUpdateFromConn(func(conn net.Conn, c *characteristic.Characteristic, newval, oldval interface{}) {
+		enqueue(a, job{c: c, prev: oldval, run: func(prev interface{}) {
+			if err := fn(newval); err != nil {
+				log.Info.Println(err.Error())
+				c.UpdateValue(prev)
+			}
+		}})
+	})
+}
+
+// the HomeKit handlers must not wait on a slow device, with retries a command can take several timeouts
+// each device gets a queue so its commands still go out in the order they were made
+const maxQueued = 16
+
+type job struct {
+	c    *characteristic.Characteristic // a later change to c replaces this one if it has not started, nil for never
+	prev interface{}                    // what c showed before the first of the changes this one replaced
+	run  func(prev interface{})
+}
+
+type devqueue struct {
+	jobs    []job
+	running bool
+}
+
+type queuemu struct {
+	mu sync.Mutex
+	q  map[string]*devqueue
+}
+
+var queues queuemu
+
+// async runs fn on the device's queue
+func async(a *tfaccessory.TFAccessory, fn func()) {
+	enqueue(a, job{run: func(interface{}) { fn() }})
+}
+
+// enqueue never blocks, an offline device retrying with backoff would otherwise hold up HomeKit
+// only the latest value of a characteristic is sent, and once the queue is full new jobs are dropped
+func enqueue(a *tfaccessory.TFAccessory, j job) {
+	queues.mu.Lock()
+	if queues.q == nil {
+		queues.q = make(map[string]*devqueue)
+	}
+	q, ok := queues.q[a.Info.SerialNumber]
+	if !ok {
+		q = &devqueue{}
+		queues.q[a.Info.SerialNumber] = q
+	}
+
+	queued := false
+	if j.c != nil {
+		for i := range q.jobs {
+			if q.jobs[i].c == j.c {
+				// the device never got the replaced value, so going back means going to what it had before that
+				j.prev = q.jobs[i].prev
+				q.jobs[i] = j
+				queued = true
+				log.Info.Printf("[%s] busy, replacing a queued change", a.Name)
+				break
+			}
+		}
+	}
+	if !queued {
+		if len(q.jobs) >= maxQueued {
+			queues.mu.Unlock()
+			log.Info.Printf("[%s] busy, dropping a change", a.Name)
+			if j.c != nil {
+				j.c.UpdateValue(j.prev)
+			}
+			return
+		}
+		q.jobs = append(q.jobs, j)
+	}
+
+	if !q.running {
+		q.running = true
+		go q.work()
+	}
+	queues.mu.Unlock()
+}
+
+func (q *devqueue) work() {
+	for {
+		queues.mu.Lock()
+		if len(q.jobs) == 0 {
+			q.running = false
+			queues.mu.Unlock()
+			return
+		}
+		j := q.jobs[0]
+		q.jobs = q.jobs[1:]
+		queues.mu.Unlock()
+
+		j.run(j.prev)
+	}
+}
@@ -0,0 +1,81 @@
+package kasa
+
+import (
+	"fmt"
+	"github.com/brutella/hc/characteristic"
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEnqueue(t *testing.T) {
+	a := &tfaccessory.TFAccessory{Name: "slow"}
+	a.Info.SerialNumber = "enqueue-test"
+
+	// the device is stuck on the first command
+	release := make(chan struct{})
+	started := make(chan struct{})
+	async(a, func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	var mu sync.Mutex
+	var ran []string
+	record := func(s string) {
+		mu.Lock()
+		ran = append(ran, s)
+		mu.Unlock()
+	}
+
+	on := characteristic.NewOn()
+	on.SetValue(false)
+	// HomeKit toggles it on, off, then on again while the device is busy
+	var reverted interface{}
+	for _, v := range []bool{true, false, true} {
+		v := v
+		enqueue(a, job{c: on.Characteristic, prev: !v, run: func(prev interface{}) {
+			record(fmt.Sprintf("on %t", v))
+			mu.Lock()
+			reverted = prev
+			mu.Unlock()
+		}})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// more than the queue holds, none of these may block
+		for i := 0; i < maxQueued+4; i++ {
+			i := i
+			async(a, func() { record(fmt.Sprintf("job %d", i)) })
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("queueing blocked on a busy device")
+	}
+
+	close(release)
+	waitFor(t, "the queue to empty", func() bool {
+		queues.mu.Lock()
+		defer queues.mu.Unlock()
+		return !queues.q[a.Info.SerialNumber].running
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ran) != maxQueued || ran[0] != "on true" {
+		t.Fatalf("ran %q", ran)
+	}
+	// the replaced changes never reached the device, a failure goes back to what it had before them
+	if reverted != false {
+		t.Errorf("would revert to %v, want false", reverted)
+	}
+	if ran[maxQueued-1] != fmt.Sprintf("job %d", maxQueued-2) {
+		t.Errorf("last job run %q", ran[maxQueued-1])
+	}
+}
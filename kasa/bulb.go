@@ -0,0 +1,194 @@
+package kasa
+
+import (
+	"encoding/json"
+	"github.com/brutella/hc/log"
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/devices"
+)
+
+const lightingService = "smartlife.iot.smartbulb.lightingservice"
+
+// kasa bulbs take color temperature in kelvin, HomeKit uses mireds
+const (
+	minKelvin = 2500
+	maxKelvin = 9000
+)
+
+func kelvinToMired(k int) int {
+	if k <= 0 {
+		return 0
+	}
+	return 1000000 / k
+}
+
+func miredToKelvin(m int) int {
+	if m <= 0 {
+		return maxKelvin
+	}
+	k := 1000000 / m
+	if k < minKelvin {
+		k = minKelvin
+	}
+	if k > maxKelvin {
+		k = maxKelvin
+	}
+	return k
+}
+
+func intp(i int) *int {
+	return &i
+}
+
+func onOff(b bool) *int {
+	if b {
+		return intp(1)
+	}
+	return intp(0)
+}
+
+// setLightState sends only the fields which are set in ls, using the accessory's configured transition period
+func setLightState(a *tfaccessory.TFAccessory, ls *lightState) error {
+	ls.Transition = a.KasaTransition
+	cmd, err := json.Marshal(map[string]interface{}{
+		lightingService: map[string]interface{}{
+			"transition_light_state": struct {
+				*lightState
+				IgnoreDefault int `json:"ignore_default"`
+			}{ls, 1},
+		},
+	})
+	if err != nil {
+		log.Info.Println(err.Error())
+		return err
+	}
+
+	err = sendCmdAck(a, string(cmd))
+	if err != nil {
+		log.Info.Println(err.Error())
+		return err
+	}
+	return nil
+}
+
+func installBulbHandlers(a *tfaccessory.TFAccessory, settings *ksysinfo) {
+	if settings.LightState != nil {
+		updateBulb(a, settings.LightState)
+	}
+
+	switch a.Device.(type) {
+	case *devices.ColoredLightbulb:
+		lb := a.Device.(*devices.ColoredLightbulb).Lightbulb
+		onRemoteUpdateOrRevert(a, lb.On.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] to [%t] from bulb handler", a.Name, newval.(bool))
+			return setLightState(a, &lightState{OnOff: onOff(newval.(bool))})
+		})
+		onRemoteUpdateOrRevert(a, lb.Brightness.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] brightness [%d] from bulb handler", a.Name, newval.(int))
+			return setLightState(a, &lightState{Brightness: intp(newval.(int))})
+		})
+		// color_temp must be 0 for the bulb to use hue/saturation
+		onRemoteUpdateOrRevert(a, lb.Hue.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] hue [%f] from bulb handler", a.Name, newval.(float64))
+			return setLightState(a, &lightState{Hue: intp(int(newval.(float64))), ColorTemp: intp(0)})
+		})
+		onRemoteUpdateOrRevert(a, lb.Saturation.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] saturation [%f] from bulb handler", a.Name, newval.(float64))
+			return setLightState(a, &lightState{Saturation: intp(int(newval.(float64))), ColorTemp: intp(0)})
+		})
+		onRemoteUpdateOrRevert(a, lb.ColorTemperature.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] color temperature [%d] from bulb handler", a.Name, newval.(int))
+			return setLightState(a, &lightState{ColorTemp: intp(miredToKelvin(newval.(int)))})
+		})
+	case *devices.TempLightbulb:
+		lb := a.Device.(*devices.TempLightbulb).Lightbulb
+		onRemoteUpdateOrRevert(a, lb.On.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] to [%t] from bulb handler", a.Name, newval.(bool))
+			return setLightState(a, &lightState{OnOff: onOff(newval.(bool))})
+		})
+		onRemoteUpdateOrRevert(a, lb.Brightness.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] brightness [%d] from bulb handler", a.Name, newval.(int))
+			return setLightState(a, &lightState{Brightness: intp(newval.(int))})
+		})
+		onRemoteUpdateOrRevert(a, lb.ColorTemperature.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] color temperature [%d] from bulb handler", a.Name, newval.(int))
+			return setLightState(a, &lightState{ColorTemp: intp(miredToKelvin(newval.(int)))})
+		})
+	case *devices.DimmableLightbulb:
+		lb := a.Device.(*devices.DimmableLightbulb).Lightbulb
+		onRemoteUpdateOrRevert(a, lb.On.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] to [%t] from bulb handler", a.Name, newval.(bool))
+			return setLightState(a, &lightState{OnOff: onOff(newval.(bool))})
+		})
+		if lb.Brightness != nil {
+			onRemoteUpdateOrRevert(a, lb.Brightness.Characteristic, func(newval interface{}) error {
+				log.Info.Printf("setting [%s] brightness [%d] from bulb handler", a.Name, newval.(int))
+				return setLightState(a, &lightState{Brightness: intp(newval.(int))})
+			})
+		}
+	}
+}
+
+// updateBulb pushes the state reported by the bulb to HomeKit
+func updateBulb(a *tfaccessory.TFAccessory, reported *lightState) {
+	ls := reported.current()
+
+	switch a.Device.(type) {
+	case *devices.ColoredLightbulb:
+		lb := a.Device.(*devices.ColoredLightbulb).Lightbulb
+		if ls.OnOff != nil && lb.On.GetValue() != (*ls.OnOff > 0) {
+			log.Info.Printf("updating HomeKit: [%s]:[%s] on %d", ipOf(a), a.Info.Name, *ls.OnOff)
+			lb.On.SetValue(*ls.OnOff > 0)
+		}
+		if ls.Brightness != nil && lb.Brightness.GetValue() != *ls.Brightness {
+			lb.Brightness.SetValue(*ls.Brightness)
+		}
+		if ls.Hue != nil && lb.Hue.GetValue() != float64(*ls.Hue) {
+			lb.Hue.SetValue(float64(*ls.Hue))
+		}
+		if ls.Saturation != nil && lb.Saturation.GetValue() != float64(*ls.Saturation) {
+			lb.Saturation.SetValue(float64(*ls.Saturation))
+		}
+		if ls.ColorTemp != nil && *ls.ColorTemp != 0 && lb.ColorTemperature.GetValue() != kelvinToMired(*ls.ColorTemp) {
+			lb.ColorTemperature.SetValue(kelvinToMired(*ls.ColorTemp))
+		}
+	case *devices.TempLightbulb:
+		lb := a.Device.(*devices.TempLightbulb).Lightbulb
+		if ls.OnOff != nil && lb.On.GetValue() != (*ls.OnOff > 0) {
+			log.Info.Printf("updating HomeKit: [%s]:[%s] on %d", ipOf(a), a.Info.Name, *ls.OnOff)
+			lb.On.SetValue(*ls.OnOff > 0)
+		}
+		if ls.Brightness != nil && lb.Brightness.GetValue() != *ls.Brightness {
+			lb.Brightness.SetValue(*ls.Brightness)
+		}
+		if ls.ColorTemp != nil && *ls.ColorTemp != 0 && lb.ColorTemperature.GetValue() != kelvinToMired(*ls.ColorTemp) {
+			lb.ColorTemperature.SetValue(kelvinToMired(*ls.ColorTemp))
+		}
+	case *devices.DimmableLightbulb:
+		lb := a.Device.(*devices.DimmableLightbulb).Lightbulb
+		if ls.OnOff != nil && lb.On.GetValue() != (*ls.OnOff > 0) {
+			log.Info.Printf("updating HomeKit: [%s]:[%s] on %d", ipOf(a), a.Info.Name, *ls.OnOff)
+			lb.On.SetValue(*ls.OnOff > 0)
+		}
+		if lb.Brightness != nil && ls.Brightness != nil && lb.Brightness.GetValue() != *ls.Brightness {
+			lb.Brightness.SetValue(*ls.Brightness)
+		}
+	default:
+		log.Info.Printf("light state for non-bulb device: %s", a.Info.Name)
+	}
+}
+
+// the response to transition_light_state is the new state of the bulb
+func doLightingResponse(a *tfaccessory.TFAccessory, res string) {
+	kd := kasaDevice{}
+	if err := json.Unmarshal([]byte(res), &kd); err != nil {
+		log.Info.Println(err.Error())
+		return
+	}
+	ls := kd.Lighting.TransitionLightState
+	if ls.ErrorCode != 0 {
+		log.Info.Printf("[%s] unable to set light state: %s", a.Info.Name, ls.ErrorMessage)
+		return
+	}
+	updateBulb(a, &ls)
+}
@@ -0,0 +1,143 @@
+package kasa
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/brutella/hc/log"
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/devices"
+)
+
+const dimmerModule = "smartlife.iot.dimmer"
+
+// the names the device uses for the double-click actions, indexed by the devices.DoubleClick constants
+var doubleClickModes = []string{"none", "instant_on_off", "gentle_on_off"}
+
+type dimmerParameters struct {
+	FadeOnTime    int  `json:"fadeOnTime"`
+	FadeOffTime   int  `json:"fadeOffTime"`
+	GentleOnTime  int  `json:"gentleOnTime"`
+	GentleOffTime int  `json:"gentleOffTime"`
+	ErrorCode     int8 `json:"err_code"`
+}
+
+type doubleClickAction struct {
+	Mode      string `json:"mode"`
+	ErrorCode int8   `json:"err_code"`
+}
+
+// installDimmerHandlers applies the settings from the accessory config, then reads the device's settings into HomeKit
+func installDimmerHandlers(a *tfaccessory.TFAccessory, hs *devices.HS220) {
+	applyDimmerConfig(a)
+	getDimmerParameters(a, hs)
+
+	hs.Lightbulb.Transition.SetValue(int(a.KasaTransition))
+
+	for _, p := range []struct {
+		c      *devices.TooFarDuration
+		method string
+		arg    string
+	}{
+		{hs.Lightbulb.FadeOnTime, "set_fade_on_time", "fadeTime"},
+		{hs.Lightbulb.FadeOffTime, "set_fade_off_time", "fadeTime"},
+		{hs.Lightbulb.GentleOnTime, "set_gentle_on_time", "duration"},
+		{hs.Lightbulb.GentleOffTime, "set_gentle_off_time", "duration"},
+	} {
+		p := p // local-only copy for this func
+		onRemoteUpdateOrRevert(a, p.c.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] %s [%d]", a.Name, p.method, newval.(int))
+			return setDimmerTime(a, p.method, p.arg, newval.(int))
+		})
+	}
+
+	onRemoteUpdateOrRevert(a, hs.Lightbulb.DoubleClick.Characteristic, func(newval interface{}) error {
+		i := newval.(int)
+		if i < 0 || i >= len(doubleClickModes) {
+			return fmt.Errorf("unknown double-click action: %d", i)
+		}
+		log.Info.Printf("setting [%s] double-click to [%s]", a.Name, doubleClickModes[i])
+		return setDoubleClick(a, doubleClickModes[i])
+	})
+}
+
+// applyDimmerConfig sends the settings which are set in the accessory config
+func applyDimmerConfig(a *tfaccessory.TFAccessory) {
+	for _, p := range []struct {
+		v      uint32
+		method string
+		arg    string
+	}{
+		{a.KasaFadeOnTime, "set_fade_on_time", "fadeTime"},
+		{a.KasaFadeOffTime, "set_fade_off_time", "fadeTime"},
+		{a.KasaGentleOnTime, "set_gentle_on_time", "duration"},
+		{a.KasaGentleOffTime, "set_gentle_off_time", "duration"},
+	} {
+		if p.v == 0 {
+			continue
+		}
+		if err := setDimmerTime(a, p.method, p.arg, int(p.v)); err != nil {
+			log.Info.Println(err.Error())
+		}
+	}
+
+	if a.KasaDoubleClick != "" {
+		if err := setDoubleClick(a, a.KasaDoubleClick); err != nil {
+			log.Info.Println(err.Error())
+		}
+	}
+}
+
+// getDimmerParameters reads the settings directly since the device is not registered with the listener yet
+func getDimmerParameters(a *tfaccessory.TFAccessory, hs *devices.HS220) {
+	res, err := transportFor(a).send(fmt.Sprintf(`{"%s":{"get_dimmer_parameters":{},"get_double_click_action":{}}}`, dimmerModule))
+	if err != nil {
+		log.Info.Println(err.Error())
+		return
+	}
+
+	var r map[string]struct {
+		Parameters  dimmerParameters  `json:"get_dimmer_parameters"`
+		DoubleClick doubleClickAction `json:"get_double_click_action"`
+	}
+	if err := json.Unmarshal([]byte(res), &r); err != nil {
+		log.Info.Println(err.Error())
+		return
+	}
+	d := r[dimmerModule]
+
+	if d.Parameters.ErrorCode == 0 {
+		hs.Lightbulb.FadeOnTime.SetValue(d.Parameters.FadeOnTime)
+		hs.Lightbulb.FadeOffTime.SetValue(d.Parameters.FadeOffTime)
+		hs.Lightbulb.GentleOnTime.SetValue(d.Parameters.GentleOnTime)
+		hs.Lightbulb.GentleOffTime.SetValue(d.Parameters.GentleOffTime)
+	}
+	if d.DoubleClick.ErrorCode == 0 {
+		for i, m := range doubleClickModes {
+			if m == d.DoubleClick.Mode {
+				hs.Lightbulb.DoubleClick.SetValue(i)
+			}
+		}
+	}
+}
+
+func setDimmerTime(a *tfaccessory.TFAccessory, method, arg string, ms int) error {
+	return sendCmdAck(a, fmt.Sprintf(`{"%s":{"%s":{"%s":%d}}}`, dimmerModule, method, arg, ms))
+}
+
+func setDoubleClick(a *tfaccessory.TFAccessory, mode string) error {
+	known := false
+	for _, m := range doubleClickModes {
+		if m == mode {
+			known = true
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown double-click action: %s", mode)
+	}
+	return sendCmdAck(a, fmt.Sprintf(`{"%s":{"set_double_click_action":{"mode":"%s"}}}`, dimmerModule, mode))
+}
+
+// setDimmerTransition fades to the brightness over ms milliseconds, 0 brightness fades off
+func setDimmerTransition(a *tfaccessory.TFAccessory, brightness, ms int) error {
+	return sendCmdAck(a, fmt.Sprintf(`{"%s":{"set_dimmer_transition":{"brightness":%d,"duration":%d}}}`, dimmerModule, brightness, ms))
+}
@@ -0,0 +1,121 @@
+package kasa
+
+import (
+	"encoding/json"
+	"github.com/brutella/hc/log"
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/config"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Discovered is a device which answered discovery but is not in the accessory config
+type Discovered struct {
+	IP        string
+	Alias     string
+	Model     string
+	DeviceID  string
+	MAC       string
+	LastSeen  time.Time
+	Accessory tfaccessory.TFAccessory // a starting point for the accessory config file
+}
+
+// keyed by deviceId
+type discoveredmu struct {
+	mu sync.Mutex
+	d  map[string]*Discovered
+}
+
+var discovered discoveredmu
+
+// discover asks every device on the configured interfaces and subnets to identify itself
+// the responses come in on the listener and unknown devices are recorded by recordDiscovered
+func discover() error {
+	c := config.Get()
+
+	bcast, err := broadcastAddresses(c.KasaInterfaces)
+	if err != nil {
+		log.Info.Println(err.Error())
+	}
+	for _, b := range bcast {
+		if err := sendUDP(b.String(), cmd_sysinfo); err != nil {
+			log.Info.Println(err.Error())
+		}
+	}
+
+	for _, cidr := range c.KasaSubnets {
+		hosts, err := subnetHosts(cidr)
+		if err != nil {
+			log.Info.Println(err.Error())
+			continue
+		}
+		for i, h := range hosts {
+			if err := sendUDP(h.String(), cmd_sysinfo); err != nil {
+				// one unreachable address should not stop the search
+				log.Info.Println(err.Error())
+				continue
+			}
+			// do not flood the network
+			if i%64 == 63 {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+	return nil
+}
+
+// recordDiscovered notes a device which answered but is not configured
+func recordDiscovered(ip, res string) {
+	kd := kasaDevice{}
+	if err := json.Unmarshal([]byte(res), &kd); err != nil {
+		// not a sysinfo response
+		return
+	}
+	s := kd.System.Sysinfo
+	if s.DeviceID == "" {
+		return
+	}
+
+	discovered.mu.Lock()
+	defer discovered.mu.Unlock()
+	d, ok := discovered.d[s.DeviceID]
+	if !ok {
+		log.Info.Printf("discovered unconfigured kasa device [%s] %s at %s", s.Alias, s.Model, ip)
+		d = &Discovered{}
+		discovered.d[s.DeviceID] = d
+	}
+	d.IP = ip
+	d.Alias = s.Alias
+	d.Model = s.Model
+	d.DeviceID = s.DeviceID
+	d.MAC = s.MAC
+	d.LastSeen = time.Now()
+	d.Accessory = tfaccessory.TFAccessory{Platform: "Kasa", IP: ip, Name: s.Alias}
+}
+
+// forgetDiscovered is called when a device is configured
+func forgetDiscovered(deviceID string) {
+	discovered.mu.Lock()
+	delete(discovered.d, deviceID)
+	discovered.mu.Unlock()
+}
+
+// DiscoveredHandler is registered with the HTTP platform
+// it lists the devices which have been seen on the network but are not configured
+func DiscoveredHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	discovered.mu.Lock()
+	list := make([]Discovered, 0, len(discovered.d))
+	for _, d := range discovered.d {
+		list = append(list, *d)
+	}
+	discovered.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].IP < list[j].IP })
+
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		log.Info.Println(err.Error())
+	}
+}
@@ -0,0 +1,225 @@
+package kasa
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/brutella/hc/log"
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/devices"
+	"github.com/gorilla/mux"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// EmeterStatus is the most recent energy reading from a device, exposed via the HTTP platform
+type EmeterStatus struct {
+	Name     string    `json:"name"`
+	IP       string    `json:"ip"`
+	Watts    float64   `json:"watts"`
+	Volts    float64   `json:"volts"`
+	Amps     float64   `json:"amps"`
+	TotalKWH float64   `json:"total_kwh"`
+	TodayKWH float64   `json:"today_kwh"`
+	MonthKWH float64   `json:"month_kwh"`
+	Updated  time.Time `json:"updated"`
+}
+
+type emu struct {
+	mu sync.Mutex
+	e  map[string]*EmeterStatus
+}
+
+var emeters emu
+
+// hasEmeter reports if the device is one we poll for energy use
+func hasEmeter(a *tfaccessory.TFAccessory) bool {
+	switch a.Device.(type) {
+	case *devices.KP115:
+		return true
+	case *devices.KasaStrip:
+		return a.Device.(*devices.KasaStrip).Emeter
+	}
+	return false
+}
+
+// a single request gets the current reading as well as this month's daily and this year's monthly totals
+func emeterCmd(now time.Time) string {
+	return fmt.Sprintf(`"emeter":{"get_realtime":{},"get_daystat":{"month":%d,"year":%d},"get_monthstat":{"year":%d}}`, now.Month(), now.Year(), now.Year())
+}
+
+// getEmeterAll asks each energy monitoring device for an update
+// single-outlet device responses are handled as they come in
+// children of strips are pulled directly since the responses do not identify the child
+func getEmeterAll() {
+	cmd := emeterCmd(time.Now())
+
+	kasas.mu.Lock()
+	var list []*tfaccessory.TFAccessory
+	for _, a := range kasas.ks {
+		if hasEmeter(a) {
+			list = append(list, a)
+		}
+	}
+	kasas.mu.Unlock()
+
+	for _, a := range list {
+		ks, ok := a.Device.(*devices.KasaStrip)
+		if !ok {
+			if err := sendCmd(a, fmt.Sprintf("{%s}", cmd)); err != nil {
+				log.Info.Println(err.Error())
+			}
+			continue
+		}
+
+		for _, outlet := range ks.Outlets {
+			res, err := transportFor(a).send(fmt.Sprintf(`{"context":{"child_ids":["%s"]},%s}`, outlet.ChildID, cmd))
+			if err != nil {
+				log.Info.Println(err.Error())
+				continue
+			}
+			kd := kasaDevice{}
+			if err := json.Unmarshal([]byte(res), &kd); err != nil {
+				log.Info.Println(err.Error())
+				continue
+			}
+			rt, ok := recordEmeter(outlet.ChildID, outlet.Name.GetValue(), ipOf(a), kd.Emeter)
+			if !ok {
+				continue
+			}
+			outlet.Volts.SetValue(rt.volts())
+			outlet.Amps.SetValue(rt.amps())
+			outlet.Watts.SetValue(rt.watts())
+			outlet.KWH.SetValue(rt.kwh())
+		}
+	}
+}
+
+func (r realtime) volts() float64 {
+	if r.VoltageMV != 0 {
+		return r.VoltageMV / 1000
+	}
+	return r.Voltage
+}
+
+func (r realtime) amps() float64 {
+	if r.CurrentMA != 0 {
+		return r.CurrentMA / 1000
+	}
+	return r.Current
+}
+
+func (r realtime) watts() float64 {
+	if r.PowerMW != 0 {
+		return r.PowerMW / 1000
+	}
+	return r.Power
+}
+
+func (r realtime) kwh() float64 {
+	if r.TotalWH != 0 {
+		return r.TotalWH / 1000
+	}
+	return r.Total
+}
+
+func (e energyStat) kwh() float64 {
+	if e.EnergyWH != 0 {
+		return e.EnergyWH / 1000
+	}
+	return e.Energy
+}
+
+// recordEmeter saves the reading for the HTTP interface, ok is false if the device reported an error
+func recordEmeter(key, name, ip string, em emeter) (realtime, bool) {
+	emeters.mu.Lock()
+	defer emeters.mu.Unlock()
+
+	e, ok := emeters.e[key]
+	if !ok {
+		e = &EmeterStatus{}
+		emeters.e[key] = e
+	}
+	e.Name = name
+	e.IP = ip
+	e.Updated = time.Now()
+
+	now := time.Now()
+	for _, d := range em.Daystat.DayList {
+		if d.Year == now.Year() && d.Month == int(now.Month()) && d.Day == now.Day() {
+			e.TodayKWH = d.kwh()
+		}
+	}
+	for _, m := range em.Monthstat.MonthList {
+		if m.Year == now.Year() && m.Month == int(now.Month()) {
+			e.MonthKWH = m.kwh()
+		}
+	}
+
+	rt := em.Realtime
+	if rt.ErrorCode != 0 {
+		log.Info.Printf("emeter error from [%s]: %s", name, rt.ErrorMessage)
+		return rt, false
+	}
+	e.Watts = rt.watts()
+	e.Volts = rt.volts()
+	e.Amps = rt.amps()
+	e.TotalKWH = rt.kwh()
+	return rt, true
+}
+
+func doEmeterResponse(a *tfaccessory.TFAccessory, res string) {
+	kd := kasaDevice{}
+	if err := json.Unmarshal([]byte(res), &kd); err != nil {
+		log.Info.Println(err.Error())
+		return
+	}
+
+	rt, ok := recordEmeter(a.Info.SerialNumber, a.Info.Name, ipOf(a), kd.Emeter)
+	if !ok {
+		return
+	}
+
+	switch a.Device.(type) {
+	case *devices.KP115:
+		kp := a.Device.(*devices.KP115)
+		kp.Outlet.Volts.SetValue(rt.volts())
+		kp.Outlet.Amps.SetValue(rt.amps())
+		kp.Outlet.Watts.SetValue(rt.watts())
+		kp.Outlet.KWH.SetValue(rt.kwh())
+	default:
+		log.Info.Printf("unhandled emeter response: %s", res)
+	}
+}
+
+// EmeterHandler is registered with the HTTP platform
+// it returns the most recent energy readings for one device (by deviceId or IP, or child ID for strips) or all devices
+func EmeterHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	emeters.mu.Lock()
+	defer emeters.mu.Unlock()
+
+	device, ok := mux.Vars(r)["device"]
+	if !ok {
+		if err := json.NewEncoder(w).Encode(emeters.e); err != nil {
+			log.Info.Println(err.Error())
+		}
+		return
+	}
+
+	e, ok := emeters.e[device]
+	if !ok {
+		// by address
+		kasas.mu.Lock()
+		e, ok = emeters.e[kasas.ips[device]]
+		kasas.mu.Unlock()
+	}
+	if !ok {
+		http.Error(w, `{ "status": "unknown device" }`, http.StatusNotFound)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(e); err != nil {
+		log.Info.Println(err.Error())
+	}
+}
@@ -0,0 +1,158 @@
+package kasa
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/log"
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/devices"
+)
+
+// bulbs use different module names for the same things
+func isBulb(a *tfaccessory.TFAccessory) bool {
+	switch a.Device.(type) {
+	case *devices.ColoredLightbulb, *devices.TempLightbulb, *devices.DimmableLightbulb:
+		return true
+	}
+	return false
+}
+
+func systemModule(a *tfaccessory.TFAccessory) string {
+	if isBulb(a) {
+		return "smartlife.iot.common.system"
+	}
+	return "system"
+}
+
+func cloudModule(a *tfaccessory.TFAccessory) string {
+	if isBulb(a) {
+		return "smartlife.iot.common.cloud"
+	}
+	return "cnCloud"
+}
+
+func getExtras(a *tfaccessory.TFAccessory) *devices.KasaExtras {
+	kasas.mu.Lock()
+	defer kasas.mu.Unlock()
+	return kasas.extras[a.Info.SerialNumber]
+}
+
+// addExtras adds the services enabled in the accessory config, must be called before the accessory is added to HC
+func addExtras(a *tfaccessory.TFAccessory, settings *ksysinfo) {
+	if !a.KasaLEDSwitch && !a.KasaRebootSwitch && !a.KasaCloudSensor {
+		return
+	}
+
+	// bulbs do not have a status LED
+	e := devices.NewKasaExtras(a.Accessory, a.KasaLEDSwitch && !isBulb(a), a.KasaRebootSwitch, a.KasaCloudSensor)
+	kasas.mu.Lock()
+	kasas.extras[a.Info.SerialNumber] = e
+	kasas.mu.Unlock()
+
+	if e.LED != nil {
+		e.LED.On.SetValue(settings.LEDOff == 0)
+		onRemoteUpdateOrRevert(a, e.LED.On.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] status LED to [%t]", a.Name, newval.(bool))
+			return setLEDOff(a, !newval.(bool))
+		})
+	}
+
+	if e.Reboot != nil {
+		e.Reboot.On.OnValueRemoteUpdate(func(newstate bool) {
+			async(a, func() {
+				if !newstate {
+					return
+				}
+				log.Info.Printf("rebooting [%s]", a.Name)
+				if err := reboot(a); err != nil {
+					log.Info.Println(err.Error())
+				}
+				e.Reboot.On.SetValue(false)
+			})
+		})
+	}
+
+	// the device is not registered yet so the listener would drop the response, ask directly
+	if e.Cloud != nil {
+		res, err := transportFor(a).send(fmt.Sprintf(`{"%s":{"get_info":{}}}`, cloudModule(a)))
+		if err != nil {
+			log.Info.Println(err.Error())
+			return
+		}
+		doCloudResponse(a, res)
+	}
+}
+
+func setLEDOff(a *tfaccessory.TFAccessory, off bool) error {
+	state := 0
+	if off {
+		state = 1
+	}
+	return sendCmdAck(a, fmt.Sprintf(`{"system":{"set_led_off":{"off":%d}}}`, state))
+}
+
+func reboot(a *tfaccessory.TFAccessory) error {
+	return sendCmdAck(a, fmt.Sprintf(`{"%s":{"reboot":{"delay":1}}}`, systemModule(a)))
+}
+
+// updateExtras brings the LED state in line with what sysinfo reports
+func updateExtras(a *tfaccessory.TFAccessory, r *ksysinfo) {
+	e := getExtras(a)
+	if e == nil || e.LED == nil {
+		return
+	}
+	if e.LED.On.GetValue() != (r.LEDOff == 0) {
+		log.Info.Printf("updating HomeKit: [%s]:[%s] led_off %d", ipOf(a), r.Alias, r.LEDOff)
+		e.LED.On.SetValue(r.LEDOff == 0)
+	}
+}
+
+// getCloudAll asks each device with a cloud binding indicator for its status, the responses are handled as they come in
+func getCloudAll() {
+	kasas.mu.Lock()
+	var list []*tfaccessory.TFAccessory
+	for id, e := range kasas.extras {
+		if a, ok := kasas.ks[id]; ok && e.Cloud != nil {
+			list = append(list, a)
+		}
+	}
+	kasas.mu.Unlock()
+
+	for _, a := range list {
+		if err := sendCmd(a, fmt.Sprintf(`{"%s":{"get_info":{}}}`, cloudModule(a))); err != nil {
+			log.Info.Println(err.Error())
+		}
+	}
+}
+
+func doCloudResponse(a *tfaccessory.TFAccessory, res string) {
+	var r map[string]struct {
+		GetInfo struct {
+			Binded    int  `json:"binded"`
+			ErrorCode int8 `json:"err_code"`
+		} `json:"get_info"`
+	}
+	if err := json.Unmarshal([]byte(res), &r); err != nil {
+		log.Info.Println(err.Error())
+		return
+	}
+	info, ok := r[cloudModule(a)]
+	if !ok || info.GetInfo.ErrorCode != 0 {
+		// unbind responses and errors
+		return
+	}
+
+	e := getExtras(a)
+	if e == nil || e.Cloud == nil {
+		return
+	}
+	state := characteristic.ContactSensorStateContactDetected
+	if info.GetInfo.Binded != 0 {
+		state = characteristic.ContactSensorStateContactNotDetected
+	}
+	if e.Cloud.ContactSensorState.GetValue() != state {
+		log.Info.Printf("updating HomeKit: [%s]:[%s] cloud binding %d", ipOf(a), a.Info.Name, info.GetInfo.Binded)
+		e.Cloud.ContactSensorState.SetValue(state)
+	}
+}
@@ -0,0 +1,302 @@
+package kasa
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/brutella/hc/log"
+	"github.com/brutella/hc/util"
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// devices are known by their deviceId, the IP address is only how to reach them right now
+// xor devices answer discovery so a new address is picked up from the response,
+// KLAP devices do not listen on UDP at all, if one moves its IP must be changed in the accessory config
+
+const (
+	aidSuffix    = ".aid"
+	configSuffix = ".config"
+)
+
+// how long to wait for the startup discovery to find a configured device which is not at its address
+const moveWait = 2 * time.Second
+
+type aidmu struct {
+	mu      sync.Mutex
+	storage util.Storage
+	used    map[uint64]string // AID -> deviceId
+	configs map[string]string // accessory config -> deviceId, to find devices which moved while the bridge was down
+}
+
+var aids aidmu
+
+// loadAIDs reads the AIDs handed out in previous runs so they stay the same across restarts
+func loadAIDs() {
+	aids.mu.Lock()
+	defer aids.mu.Unlock()
+
+	aids.used = make(map[uint64]string)
+	aids.configs = make(map[string]string)
+	storage, err := util.NewFileStorage("kasa")
+	if err != nil {
+		log.Info.Printf("unable to get storage, kasa AIDs will not persist: %s", err.Error())
+		return
+	}
+	aids.storage = storage
+
+	keys, err := storage.KeysWithSuffix(aidSuffix)
+	if err != nil {
+		log.Info.Println(err.Error())
+		return
+	}
+	for _, k := range keys {
+		b, err := storage.Get(k)
+		if err != nil {
+			log.Info.Println(err.Error())
+			continue
+		}
+		id, err := strconv.ParseUint(string(b), 10, 64)
+		if err != nil {
+			log.Info.Println(err.Error())
+			continue
+		}
+		aids.used[id] = strings.TrimSuffix(k, aidSuffix)
+	}
+
+	keys, err = storage.KeysWithSuffix(configSuffix)
+	if err != nil {
+		log.Info.Println(err.Error())
+		return
+	}
+	for _, k := range keys {
+		b, err := storage.Get(k)
+		if err != nil {
+			log.Info.Println(err.Error())
+			continue
+		}
+		aids.configs[string(b)] = strings.TrimSuffix(k, configSuffix)
+	}
+}
+
+// stableAID returns the AID for a device, handing out and saving a new one the first time it is seen
+func stableAID(deviceID string) uint64 {
+	aids.mu.Lock()
+	defer aids.mu.Unlock()
+
+	for id, dev := range aids.used {
+		if dev == deviceID {
+			return id
+		}
+	}
+
+	// a device paired before AIDs were saved keeps the one it had, so HomeKit does not see it as new
+	// where two devices had the same one, the second gets a new AID and has to be set up again in HomeKit
+	id := legacyAID(deviceID)
+	if _, taken := aids.used[id]; taken || id <= 1 {
+		h := fnv.New32a()
+		h.Write([]byte(deviceID))
+		id = uint64(h.Sum32())
+		// 1 is the bridge, keep the low numbers for the other platforms
+		if id < 1000000 {
+			id += 1000000
+		}
+		for {
+			if _, taken := aids.used[id]; !taken {
+				break
+			}
+			id++
+		}
+		log.Info.Printf("new HomeKit AID %d for %s, HomeKit will see it as a new accessory", id, deviceID)
+	}
+	aids.used[id] = deviceID
+
+	if aids.storage != nil {
+		if err := aids.storage.Set(deviceID+aidSuffix, []byte(strconv.FormatUint(id, 10))); err != nil {
+			log.Info.Println(err.Error())
+		}
+	}
+	return id
+}
+
+// legacyAID is the AID older versions derived from the deviceId, they collide and overflow so it is only used to migrate
+func legacyAID(deviceID string) uint64 {
+	if len(deviceID) < 12 {
+		return 0
+	}
+	mac, err := hex.DecodeString(deviceID[:12])
+	if err != nil {
+		return 0
+	}
+	var id uint64
+	for k, v := range mac {
+		id += uint64(v) << (12 - k) * 8
+	}
+	return id
+}
+
+// configKey identifies the accessory config a device came from
+func configKey(a *tfaccessory.TFAccessory) string {
+	if a.ConfigFile != "" {
+		return a.ConfigFile
+	}
+	return a.Name
+}
+
+// rememberConfig saves which device the accessory config found, so it can be looked for if it moves
+func rememberConfig(a *tfaccessory.TFAccessory, deviceID string) {
+	key := configKey(a)
+	if key == "" {
+		return
+	}
+
+	aids.mu.Lock()
+	defer aids.mu.Unlock()
+	if aids.configs[key] == deviceID {
+		return
+	}
+	aids.configs[key] = deviceID
+	if aids.storage != nil {
+		if err := aids.storage.Set(deviceID+configSuffix, []byte(key)); err != nil {
+			log.Info.Println(err.Error())
+		}
+	}
+}
+
+// configuredID is the deviceId the accessory config is for, the SerialNumber if the config sets it, otherwise the one it found last time
+func configuredID(a *tfaccessory.TFAccessory) string {
+	if a.Info.SerialNumber != "" {
+		return a.Info.SerialNumber
+	}
+	aids.mu.Lock()
+	defer aids.mu.Unlock()
+	return aids.configs[configKey(a)]
+}
+
+// ipOf is the device's current address, it changes when the device moves
+func ipOf(a *tfaccessory.TFAccessory) string {
+	kasas.mu.Lock()
+	defer kasas.mu.Unlock()
+	return a.IP
+}
+
+// the configured devices being looked for, HC is started once they are all found or given up on
+var moving sync.WaitGroup
+
+// lookFor runs awaitMove alongside the other devices rather than holding up each one in turn
+// gone is set when nothing answers at the configured address, so it is not swept
+func lookFor(a *tfaccessory.TFAccessory, gone bool) {
+	moving.Add(1)
+	go func() {
+		defer moving.Done()
+		if !awaitMove(a) && gone {
+			ignore(ipOf(a))
+		}
+	}()
+}
+
+// WaitForMoves returns once every configured device which was not at its address has been found or given up on
+// HC only publishes the accessories it is started with
+func WaitForMoves() {
+	moving.Wait()
+}
+
+// awaitMove is called when a configured device is not at its address
+// if the startup discovery finds it elsewhere it is added there, otherwise it is logged when it answers discovery
+// it returns true if the device was found
+func awaitMove(a *tfaccessory.TFAccessory) bool {
+	id := configuredID(a)
+	if id == "" {
+		return false
+	}
+
+	var ip string
+	for deadline := time.Now().Add(moveWait); ; time.Sleep(50 * time.Millisecond) {
+		discovered.mu.Lock()
+		if d, ok := discovered.d[id]; ok {
+			ip = d.IP
+		}
+		discovered.mu.Unlock()
+		if (ip != "" && ip != ipOf(a)) || time.Now().After(deadline) {
+			break
+		}
+	}
+
+	if old := ipOf(a); ip != "" && ip != old {
+		log.Info.Printf("[%s] moved from %s to %s", a.Name, old, ip)
+		kasas.mu.Lock()
+		a.IP = ip
+		kasas.mu.Unlock()
+		Platform{}.AddAccessory(a)
+		return true
+	}
+
+	log.Info.Printf("[%s] is not at %s and was not found", a.Name, ipOf(a))
+	kasas.mu.Lock()
+	kasas.missing[id] = a
+	kasas.mu.Unlock()
+	return false
+}
+
+// reportMissing logs a configured device which was not found at startup when it answers from a new address
+// HC does not publish accessories added after it starts, the next restart finds it there
+func reportMissing(ip, res string) bool {
+	if !strings.Contains(res, `"get_sysinfo"`) {
+		return false
+	}
+	kd := kasaDevice{}
+	if err := json.Unmarshal([]byte(res), &kd); err != nil {
+		return false
+	}
+
+	kasas.mu.Lock()
+	a, ok := kasas.missing[kd.System.Sysinfo.DeviceID]
+	if ok {
+		// only said once
+		delete(kasas.missing, kd.System.Sysinfo.DeviceID)
+		log.Info.Printf("[%s] answered from %s instead of %s, restart the bridge to add it", a.Name, ip, a.IP)
+	}
+	kasas.mu.Unlock()
+	return ok
+}
+
+// byIP finds a device by its current address
+func byIP(ip string) (*tfaccessory.TFAccessory, bool) {
+	kasas.mu.Lock()
+	defer kasas.mu.Unlock()
+	a, ok := kasas.ks[kasas.ips[ip]]
+	return a, ok
+}
+
+// claimResponse matches a response from an unknown address to a configured device by deviceId,
+// moving the device to the new address if it has changed (DHCP)
+func claimResponse(ip, res string) (*tfaccessory.TFAccessory, bool) {
+	if !strings.Contains(res, `"get_sysinfo"`) {
+		return nil, false
+	}
+	kd := kasaDevice{}
+	if err := json.Unmarshal([]byte(res), &kd); err != nil {
+		return nil, false
+	}
+
+	kasas.mu.Lock()
+	defer kasas.mu.Unlock()
+	a, ok := kasas.ks[kd.System.Sysinfo.DeviceID]
+	if !ok {
+		return nil, false
+	}
+
+	log.Info.Printf("[%s] moved from %s to %s", a.Info.Name, a.IP, ip)
+	delete(kasas.ips, a.IP)
+	kasas.ips[ip] = a.Info.SerialNumber
+	a.IP = ip
+	if _, ok := kasas.transports[a.Info.SerialNumber].(*klap); ok {
+		kasas.transports[a.Info.SerialNumber] = newKLAP(ip, a.Username, a.Password)
+	} else {
+		kasas.transports[a.Info.SerialNumber] = xor{ip: ip}
+	}
+	return a, true
+}
@@ -2,7 +2,6 @@ package kasa
 
 import (
 	"bytes"
-	"encoding/hex"
 	// "encoding/json"
 	"fmt"
 	"github.com/brutella/hc/accessory"
@@ -14,6 +13,7 @@ import (
 	"github.com/cloudkucooland/toofar/devices"
 	"github.com/cloudkucooland/toofar/platform"
 	"net"
+	"strings"
 	"sync"
 	"time"
 )
@@ -40,10 +40,15 @@ type Platform struct {
 	Running bool
 }
 
+// ks, transports and extras are keyed by deviceId, ips maps the current address to the deviceId
 type kmu struct {
-	mu     sync.Mutex
-	ks     map[string]*tfaccessory.TFAccessory
-	ignore map[string]bool
+	mu         sync.Mutex
+	ks         map[string]*tfaccessory.TFAccessory
+	ips        map[string]string
+	ignore     map[string]bool
+	missing    map[string]*tfaccessory.TFAccessory // configured devices not at their address, by deviceId
+	transports map[string]transport
+	extras     map[string]*devices.KasaExtras
 }
 
 var kasas kmu
@@ -52,9 +57,17 @@ var kasaUDPconn *net.UDPConn
 // Startup is called by the platform management to start the platform up
 func (k Platform) Startup(c *config.Config) platform.Control {
 	kasas.ks = make(map[string]*tfaccessory.TFAccessory)
+	kasas.ips = make(map[string]string)
 	kasas.ignore = make(map[string]bool)
-
-	udpl, err := net.ListenUDP("udp", &net.UDPAddr{IP: nil, Port: 9999})
+	kasas.missing = make(map[string]*tfaccessory.TFAccessory)
+	kasas.transports = make(map[string]transport)
+	kasas.extras = make(map[string]*devices.KasaExtras)
+	emeters.e = make(map[string]*EmeterStatus)
+	discovered.d = make(map[string]*Discovered)
+	loadAIDs()
+
+	// devices answer to whatever port the request came from, so there is no need to hold 9999
+	udpl, err := net.ListenUDP("udp4", &net.UDPAddr{IP: nil, Port: 0})
 	if err != nil {
 		log.Info.Printf("unable to start kasa UDP listener: %s", err.Error())
 		return k
@@ -62,8 +75,9 @@ func (k Platform) Startup(c *config.Config) platform.Control {
 	kasaUDPconn = udpl
 
 	go func() {
-		buffer := make([]byte, 1024)
-		log.Info.Println("starting kasa UDP listener")
+		// emeter daystat responses can be several kilobytes
+		buffer := make([]byte, 4096)
+		log.Info.Printf("starting kasa UDP listener on %s", kasaUDPconn.LocalAddr().String())
 		for {
 			n, addr, err := kasaUDPconn.ReadFromUDP(buffer)
 			if err != nil {
@@ -76,8 +90,9 @@ func (k Platform) Startup(c *config.Config) platform.Control {
 		// return
 	}()
 
-	// time.Sleep(time.Second)
-	broadcastCmd(cmd_sysinfo)
+	if err := discover(); err != nil {
+		log.Info.Println(err.Error())
+	}
 
 	k.Running = true
 	return k
@@ -103,72 +118,86 @@ func (k Platform) AddAccessory(a *tfaccessory.TFAccessory) {
 		return
 	}
 
+	t, err := detectTransport(a)
+	if err != nil {
+		log.Info.Printf("unable to reach kasa device, skipping: %s", err.Error())
+		lookFor(a, true)
+		return
+	}
+
 	// override the config file with reality
-	settings, err := getSettingsTCP(a)
+	settings, err := getSettings(t)
 	if err != nil {
 		log.Info.Printf("unable to identify kasa device, skipping: %s", err.Error())
-		kasas.ignore[a.IP] = true
+		lookFor(a, true)
+		return
+	}
+	if settings.DeviceID == "" {
+		log.Info.Printf("kasa device at %s did not report a deviceId, skipping", a.IP)
+		ignore(a.IP)
 		return
 	}
+	kasas.mu.Lock()
+	dup, ok := kasas.ks[settings.DeviceID]
+	var dupIP string
+	if ok {
+		dupIP = dup.IP
+	}
+	kasas.mu.Unlock()
+	if ok {
+		log.Info.Printf("%s is already configured as %s, skipping", a.IP, dupIP)
+		return
+	}
+	// DHCP gave the address to another device
+	if id := configuredID(a); id != "" && id != settings.DeviceID {
+		log.Info.Printf("[%s] is configured at %s but %s is there now", a.Name, a.IP, settings.Alias)
+		lookFor(a, false)
+		return
+	}
+	rememberConfig(a, settings.DeviceID)
+
 	a.Info.Name = settings.Alias
 	a.Info.SerialNumber = settings.DeviceID
 	a.Info.Manufacturer = "TP-Link"
 	a.Info.Model = settings.Model
 	a.Info.FirmwareRevision = settings.SWVersion
 
-	// convert 12 chars of the deviceId into a uint64 for the ID
-	mac, err := hex.DecodeString(settings.DeviceID[:12])
-	if err != nil {
-		log.Info.Printf("weird kasa devid: %s", err.Error())
-	}
-	for k, v := range mac {
-		a.Info.ID += uint64(v) << (12 - k) * 8
+	// the same AID every time so HomeKit keeps rooms and automations across restarts and address changes
+	if a.Info.ID == 0 {
+		a.Info.ID = stableAID(settings.DeviceID)
 	}
 
-	switch settings.Model {
-	case "KP303(US)":
-		a.Type = accessory.TypeSwitch
-		kp := devices.NewKP303(a.Info)
-		a.Device = kp
-		a.Accessory = kp.Accessory
-	case "HS200(US)", "HS210(US)":
-		a.Type = accessory.TypeSwitch
-		d := devices.NewHS200(a.Info)
-		a.Device = d
-		a.Accessory = d.Accessory
-	case "HS103(US)":
-		a.Type = accessory.TypeSwitch
-		d := devices.NewHS103(a.Info)
-		a.Device = d
-		a.Accessory = d.Accessory
-	case "KP115(US)":
-		a.Type = accessory.TypeSwitch
-		d := devices.NewKP115(a.Info)
-		a.Device = d
-		a.Accessory = d.Accessory
-	case "HS220(US)":
-		a.Type = accessory.TypeLightbulb
-		hs := devices.NewHS220(a.Info)
-		a.Device = hs
-		a.Accessory = hs.Accessory
+	kasas.mu.Lock()
+	kasas.transports[settings.DeviceID] = t
+	kasas.mu.Unlock()
+
+	if err := buildDevice(a, settings); err != nil {
+		log.Info.Printf("skipping [%s]: %s", a.Info.Name, err.Error())
+		ignore(a.IP)
+		return
 	}
 
+	addExtras(a, settings)
+
 	log.Info.Printf("adding [%s]: [%s]", a.Info.Name, a.Info.Model)
 	// add to HC for GUI
 	hc.AddAccessory(a)
 	a.Accessory.Info.Name.OnValueRemoteUpdate(func(newname string) {
-		log.Info.Print("setting alias to [%s]", newname)
-		err := setRelayAlias(a, newname)
-		if err != nil {
-			log.Info.Println(err.Error())
-			return
-		}
+		async(a, func() {
+			log.Info.Printf("setting alias to [%s]", newname)
+			err := setRelayAlias(a, newname)
+			if err != nil {
+				log.Info.Println(err.Error())
+				return
+			}
+		})
 	})
 
-	// kasas are indexed by IP address
 	kasas.mu.Lock()
-	kasas.ks[a.IP] = a
+	kasas.ks[settings.DeviceID] = a
+	kasas.ips[a.IP] = settings.DeviceID
 	kasas.mu.Unlock()
+	forgetDiscovered(settings.DeviceID)
 
 	switch a.Device.(type) {
 	case *accessory.Switch:
@@ -177,117 +206,200 @@ func (k Platform) AddAccessory(a *tfaccessory.TFAccessory) {
 		sw.Switch.On.SetValue(settings.RelayState > 0)
 
 		// install callbacks: if we get an update from HC, deal with it
-		sw.Switch.On.OnValueRemoteUpdate(func(newstate bool) {
-			log.Info.Printf("setting [%s] to [%t] from Kasa generic switch handler", a.Name, newstate)
-			err := setRelayState(a, newstate)
-			if err != nil {
-				log.Info.Println(err.Error())
-				return
-			}
+		onRemoteUpdateOrRevert(a, sw.Switch.On.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] to [%t] from Kasa generic switch handler", a.Name, newval.(bool))
+			return setRelayState(a, newval.(bool))
 		})
 	case *devices.HS200: // and 210
 		sw := a.Device.(*devices.HS200)
 		sw.Switch.On.SetValue(settings.RelayState > 0)
-		sw.Switch.On.OnValueRemoteUpdate(func(newstate bool) {
-			log.Info.Printf("setting [%s] to [%t] from HS200 handler", a.Name, newstate)
-			err := setRelayState(a, newstate)
-			if err != nil {
-				log.Info.Println(err.Error())
-				return
-			}
+		onRemoteUpdateOrRevert(a, sw.Switch.On.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] to [%t] from HS200 handler", a.Name, newval.(bool))
+			return setRelayState(a, newval.(bool))
 		})
 	case *devices.KP115:
 		kp := a.Device.(*devices.KP115)
 		kp.Outlet.On.SetValue(settings.RelayState > 0)
 		kp.Outlet.OutletInUse.SetValue(settings.RelayState > 0)
 
-		kp.Outlet.On.OnValueRemoteUpdate(func(newstate bool) {
-			log.Info.Printf("setting [%s] to [%t] from KP115 handler", a.Name, newstate)
-			err := setRelayState(a, newstate)
-			if err != nil {
-				log.Info.Println(err.Error())
-				return
+		onRemoteUpdateOrRevert(a, kp.Outlet.On.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] to [%t] from KP115 handler", a.Name, newval.(bool))
+			if err := setRelayState(a, newval.(bool)); err != nil {
+				return err
 			}
-			kp.Outlet.OutletInUse.SetValue(newstate)
+			kp.Outlet.OutletInUse.SetValue(newval.(bool))
+			return nil
 		})
 	case *devices.HS103:
 		hs := a.Device.(*devices.HS103)
 		hs.Outlet.On.SetValue(settings.RelayState > 0)
 		hs.Outlet.OutletInUse.SetValue(settings.RelayState > 0)
 
-		hs.Outlet.On.OnValueRemoteUpdate(func(newstate bool) {
-			log.Info.Printf("setting [%s] to [%t] from HS103 handler", a.Name, newstate)
-			err := setRelayState(a, newstate)
-			if err != nil {
-				log.Info.Println(err.Error())
-				return
+		onRemoteUpdateOrRevert(a, hs.Outlet.On.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] to [%t] from HS103 handler", a.Name, newval.(bool))
+			if err := setRelayState(a, newval.(bool)); err != nil {
+				return err
 			}
-			hs.Outlet.OutletInUse.SetValue(newstate)
+			hs.Outlet.OutletInUse.SetValue(newval.(bool))
+			return nil
 		})
 	case *devices.HS220:
 		hs := a.Device.(*devices.HS220)
 		hs.Lightbulb.On.SetValue(settings.RelayState > 0)
-		hs.Lightbulb.On.OnValueRemoteUpdate(func(newstate bool) {
+		onRemoteUpdateOrRevert(a, hs.Lightbulb.On.Characteristic, func(newval interface{}) error {
+			newstate := newval.(bool)
 			log.Info.Printf("setting [%s] to [%t] from HS220 handler", a.Name, newstate)
-			err := setRelayState(a, newstate)
-			if err != nil {
-				log.Info.Println(err.Error())
-				return
+			if t := hs.Lightbulb.Transition.GetValue(); t > 0 {
+				brightness := 0
+				if newstate {
+					brightness = hs.Lightbulb.Brightness.GetValue()
+				}
+				return setDimmerTransition(a, brightness, t)
 			}
+			return setRelayState(a, newstate)
 		})
-		hs.Lightbulb.Brightness.SetValue(settings.Brightness)
-		hs.Lightbulb.Brightness.OnValueRemoteUpdate(func(newval int) {
-			log.Info.Printf("setting [%s] brightness [%d] from HS220 handler", a.Name, newval)
-			err := setBrightness(a, newval)
-			if err != nil {
-				log.Info.Println(err.Error())
-				return
+		hs.Lightbulb.Brightness.SetValue(*settings.Brightness)
+		onRemoteUpdateOrRevert(a, hs.Lightbulb.Brightness.Characteristic, func(newval interface{}) error {
+			log.Info.Printf("setting [%s] brightness [%d] from HS220 handler", a.Name, newval.(int))
+			if t := hs.Lightbulb.Transition.GetValue(); t > 0 {
+				return setDimmerTransition(a, newval.(int), t)
 			}
+			return setBrightness(a, newval.(int))
 		})
+		installDimmerHandlers(a, hs)
 		hs.Lightbulb.SetDuration.OnValueRemoteUpdate(func(newval int) {
-			if hs.Lightbulb.ProgramMode.GetValue() != characteristic.ProgramModeNoProgramScheduled {
-				log.Info.Println("a countdown is already active, ignoring request")
-				return
-			}
-			log.Info.Println("setting up countdown action")
-			current := hs.Lightbulb.On.GetValue()
-			err := setProgramState(a, !current, newval)
-			if err != nil {
-				log.Info.Println(err.Error())
-				return
-			}
-			hs.Lightbulb.ProgramMode.SetValue(characteristic.ProgramModeProgramScheduled)
-		})
-	case *devices.KP303:
-		kp := a.Device.(*devices.KP303)
-		for i := 0; i < len(kp.Outlets); i++ {
-			n := characteristic.NewName()
-			n.SetValue(settings.Children[i].Alias)
-			outlet := kp.Outlets[i]
-			outlet.AddCharacteristic(n.Characteristic)
-
-			l := i // local-only copy for this func
-			n.OnValueRemoteUpdate(func(newname string) {
-				log.Info.Print("setting alias to [%s]", newname)
-				err := setChildRelayAlias(a, settings.Children[l].ID, newname)
+			async(a, func() {
+				if hs.Lightbulb.ProgramMode.GetValue() != characteristic.ProgramModeNoProgramScheduled {
+					log.Info.Println("a countdown is already active, ignoring request")
+					return
+				}
+				log.Info.Println("setting up countdown action")
+				current := hs.Lightbulb.On.GetValue()
+				err := setProgramState(a, !current, newval)
 				if err != nil {
 					log.Info.Println(err.Error())
 					return
 				}
+				hs.Lightbulb.ProgramMode.SetValue(characteristic.ProgramModeProgramScheduled)
 			})
+		})
+	case *devices.ColoredLightbulb, *devices.TempLightbulb, *devices.DimmableLightbulb:
+		installBulbHandlers(a, settings)
+	case *devices.KasaStrip:
+		ks := a.Device.(*devices.KasaStrip)
+		for i, outlet := range ks.Outlets {
+			c := settings.Children[i]
+			outlet.ChildID = c.ID
+			outlet.OnTime = c.OnTime
+			outlet.Name.SetValue(c.Alias)
 
-			outlet.On.SetValue(settings.Children[i].RelayState > 0)
-			outlet.OutletInUse.SetValue(settings.Children[i].RelayState > 0)
-			outlet.On.OnValueRemoteUpdate(func(newstate bool) {
-				log.Info.Printf("setting [%s].[%d] to [%t] from KP303 handler", a.Name, l, newstate)
-				err := setChildRelayState(a, settings.Children[l].ID, newstate)
-				if err != nil {
-					log.Info.Println(err.Error())
-					return
+			l := i // local-only copy for this func
+			outlet.Name.OnValueRemoteUpdate(func(newname string) {
+				async(a, func() {
+					log.Info.Printf("setting alias to [%s]", newname)
+					err := setChildRelayAlias(a, ks.Outlets[l].ChildID, newname)
+					if err != nil {
+						log.Info.Println(err.Error())
+						return
+					}
+				})
+			})
+
+			outlet.On.SetValue(c.RelayState > 0)
+			outlet.OutletInUse.SetValue(c.RelayState > 0)
+			onRemoteUpdateOrRevert(a, outlet.On.Characteristic, func(newval interface{}) error {
+				log.Info.Printf("setting [%s].[%d] to [%t] from KasaStrip handler", a.Name, l, newval.(bool))
+				if err := setChildRelayState(a, ks.Outlets[l].ChildID, newval.(bool)); err != nil {
+					return err
 				}
+				ks.Outlets[l].OutletInUse.SetValue(newval.(bool))
+				return nil
 			})
+			outlet.SetDuration.OnValueRemoteUpdate(func(newval int) {
+				async(a, func() {
+					o := ks.Outlets[l]
+					if o.ProgramMode.GetValue() != characteristic.ProgramModeNoProgramScheduled {
+						log.Info.Println("a countdown is already active, ignoring request")
+						return
+					}
+					log.Info.Printf("setting up countdown action on [%s].[%d]", a.Name, l)
+					err := setChildProgramState(a, o.ChildID, !o.On.GetValue(), newval)
+					if err != nil {
+						log.Info.Println(err.Error())
+						return
+					}
+					o.ProgramMode.SetValue(characteristic.ProgramModeProgramScheduled)
+					o.RemainingDuration.SetValue(newval)
+				})
+			})
+		}
+	}
+}
+
+// buildDevice picks the HomeKit device type from what the device reports it can do rather than from the model name,
+// so regional variants (UK/EU/AU) and new models work without changes
+func buildDevice(a *tfaccessory.TFAccessory, s *ksysinfo) error {
+	switch mic := s.micType(); mic {
+	case "IOT.SMARTPLUGSWITCH":
+		// handled below
+	case "IOT.SMARTBULB":
+		if s.IsColor != 0 {
+			a.Type = accessory.TypeLightbulb
+			lb := devices.NewColoredLightbulb(a.Info)
+			a.Device = lb
+			a.Accessory = lb.Accessory
+			return nil
 		}
+		if s.IsVariableColorTemp != 0 {
+			a.Type = accessory.TypeLightbulb
+			lb := devices.NewTempLightbulb(a.Info)
+			a.Device = lb
+			a.Accessory = lb.Accessory
+			return nil
+		}
+		// white only, such as the KL110, HomeKit must not offer a color temperature the bulb rejects
+		a.Type = accessory.TypeLightbulb
+		lb := devices.NewDimmableLightbulb(a.Info, s.IsDimmable != 0)
+		a.Device = lb
+		a.Accessory = lb.Accessory
+		return nil
+	default:
+		return fmt.Errorf("unsupported kasa device type: %s (%s)", mic, s.Model)
+	}
+
+	switch {
+	case len(s.Children) > 0:
+		// anything with children is a strip
+		a.Type = accessory.TypeOutlet
+		ks := devices.NewKasaStrip(a.Info, len(s.Children), s.hasFeature("ENE"))
+		a.Device = ks
+		a.Accessory = ks.Accessory
+	case s.Brightness != nil:
+		// dimmers
+		a.Type = accessory.TypeLightbulb
+		hs := devices.NewHS220(a.Info)
+		a.Device = hs
+		a.Accessory = hs.Accessory
+	case s.hasFeature("ENE"):
+		// outlets with energy monitoring
+		a.Type = accessory.TypeOutlet
+		d := devices.NewKP115(a.Info)
+		a.Device = d
+		a.Accessory = d.Accessory
+	case strings.Contains(s.DevName, "Switch"):
+		// in-wall switches
+		a.Type = accessory.TypeSwitch
+		d := devices.NewHS200(a.Info)
+		a.Device = d
+		a.Accessory = d.Accessory
+	default:
+		// plain outlets
+		a.Type = accessory.TypeOutlet
+		d := devices.NewHS103(a.Info)
+		a.Device = d
+		a.Accessory = d.Accessory
 	}
+	return nil
 }
 
 func setRelayState(a *tfaccessory.TFAccessory, newstate bool) error {
@@ -296,7 +408,7 @@ func setRelayState(a *tfaccessory.TFAccessory, newstate bool) error {
 		state = 1
 	}
 	cmd := fmt.Sprintf(`{"system":{"set_relay_state":{"state":%d}}}`, state)
-	err := sendUDP(a.IP, cmd)
+	err := sendCmdAck(a, cmd)
 	if err != nil {
 		log.Info.Println(err.Error())
 		return err
@@ -310,7 +422,7 @@ func setChildRelayState(a *tfaccessory.TFAccessory, childID string, newstate boo
 		state = 1
 	}
 	cmd := fmt.Sprintf(`{"context":{"child_ids":["%s"]},"system":{"set_relay_state":{"state":%d}}}`, childID, state)
-	err := sendUDP(a.IP, cmd)
+	err := sendCmdAck(a, cmd)
 	if err != nil {
 		log.Info.Println(err.Error())
 		return err
@@ -319,7 +431,7 @@ func setChildRelayState(a *tfaccessory.TFAccessory, childID string, newstate boo
 }
 
 func setProgramState(a *tfaccessory.TFAccessory, target bool, t int) error {
-	err := sendUDP(a.IP, `{"count_down":{"get_rules":{}}}`)
+	err := sendCmd(a, `{"count_down":{"get_rules":{}}}`)
 	if err != nil {
 		log.Info.Println(err.Error())
 		return err
@@ -330,7 +442,27 @@ func setProgramState(a *tfaccessory.TFAccessory, target bool, t int) error {
 		state = 1
 	}
 	cmd := fmt.Sprintf(`{"count_down":{"add_rule":{"enable":1,"delay":%d,"act":%d,"name":"TooFar"}}}`, t, state)
-	err = sendUDP(a.IP, cmd)
+	err = sendCmdAck(a, cmd)
+	if err != nil {
+		log.Info.Println(err.Error())
+		return err
+	}
+	return nil
+}
+
+// setChildProgramState is setProgramState for one outlet of a strip, each child keeps its own countdown rules
+func setChildProgramState(a *tfaccessory.TFAccessory, childID string, target bool, t int) error {
+	// the child only holds one countdown rule, clear any stale ones first
+	if err := deleteChildCountdown(a, childID); err != nil {
+		return err
+	}
+
+	var state uint8
+	if target {
+		state = 1
+	}
+	cmd := fmt.Sprintf(`{"context":{"child_ids":["%s"]},"count_down":{"add_rule":{"enable":1,"delay":%d,"act":%d,"name":"TooFar"}}}`, childID, t, state)
+	err := sendCmdAck(a, cmd)
 	if err != nil {
 		log.Info.Println(err.Error())
 		return err
@@ -339,7 +471,7 @@ func setProgramState(a *tfaccessory.TFAccessory, target bool, t int) error {
 }
 
 func deleteCountdown(a *tfaccessory.TFAccessory) error {
-	err := sendUDP(a.IP, `{"count_down":{"delete_all_rules":{}}}`)
+	err := sendCmd(a, `{"count_down":{"delete_all_rules":{}}}`)
 	if err != nil {
 		log.Info.Println(err.Error())
 		return err
@@ -349,7 +481,7 @@ func deleteCountdown(a *tfaccessory.TFAccessory) error {
 
 func setRelayAlias(a *tfaccessory.TFAccessory, newname string) error {
 	cmd := fmt.Sprintf(`{"system":{"set_alias":{"alias":"%s"}}}`, newname)
-	err := sendUDP(a.IP, cmd)
+	err := sendCmdAck(a, cmd)
 	if err != nil {
 		log.Info.Println(err.Error())
 		return err
@@ -359,7 +491,7 @@ func setRelayAlias(a *tfaccessory.TFAccessory, newname string) error {
 
 func setChildRelayAlias(a *tfaccessory.TFAccessory, childID string, newname string) error {
 	cmd := fmt.Sprintf(`{"context":{"child_ids":["%s"]},"system":{"set_alias":{"alias":"%s"}}}`, childID, newname)
-	err := sendUDP(a.IP, cmd)
+	err := sendCmdAck(a, cmd)
 	if err != nil {
 		log.Info.Println(err.Error())
 		return err
@@ -369,7 +501,7 @@ func setChildRelayAlias(a *tfaccessory.TFAccessory, childID string, newname stri
 
 func setBrightness(a *tfaccessory.TFAccessory, newval int) error {
 	cmd := fmt.Sprintf(`{"smartlife.iot.dimmer":{"set_brightness":{"brightness":%d}}}`, newval)
-	err := sendUDP(a.IP, cmd)
+	err := sendCmdAck(a, cmd)
 	if err != nil {
 		log.Info.Println(err.Error())
 		return err
@@ -377,16 +509,27 @@ func setBrightness(a *tfaccessory.TFAccessory, newval int) error {
 	return nil
 }
 
-// GetAccessory looks up a Kasa device by IP address
+// ignore stops adding the address as an unknown device, it did not work the first time
+func ignore(ip string) {
+	kasas.mu.Lock()
+	kasas.ignore[ip] = true
+	kasas.mu.Unlock()
+}
+
+func ignored(ip string) bool {
+	kasas.mu.Lock()
+	defer kasas.mu.Unlock()
+	return kasas.ignore[ip]
+}
+
+// GetAccessory looks up a Kasa device by its current IP address
 func (k Platform) GetAccessory(ip string) (*tfaccessory.TFAccessory, bool) {
-	// kasas.mu.Lock()
-	val, ok := kasas.ks[ip]
-	// kasas.mu.Unlock()
-	return val, ok
+	return byIP(ip)
 }
 
+// Discover looks for devices on the configured interfaces and subnets
 func (k Platform) Discover() error {
-	return broadcastCmd(cmd_sysinfo)
+	return discover()
 }
 
 func getSettingBroadcast() error {
@@ -397,12 +540,44 @@ func getCountdownBroadcast() error {
 	return broadcastCmd(cmd_countdown)
 }
 
+// pullKLAP does for klap devices what the broadcasts do for the others
+func pullKLAP() {
+	kasas.mu.Lock()
+	var list []*tfaccessory.TFAccessory
+	for id, a := range kasas.ks {
+		if _, ok := kasas.transports[id].(*klap); ok {
+			list = append(list, a)
+		}
+	}
+	kasas.mu.Unlock()
+
+	for _, a := range list {
+		for _, cmd := range []string{cmd_sysinfo, cmd_countdown} {
+			if err := sendCmd(a, cmd); err != nil {
+				log.Info.Println(err.Error())
+			}
+		}
+	}
+}
+
+// broadcastCmd sends to every device on the configured interfaces,
+// and directly to configured xor devices in the subnets broadcasts do not reach
 func broadcastCmd(cmd string) error {
-	bcast, err := broadcastAddresses()
+	c := config.Get()
+	bcast, err := broadcastAddresses(c.KasaInterfaces)
 	if err != nil {
 		return err
 	}
 
+	kasas.mu.Lock()
+	var unicast []string
+	for id, a := range kasas.ks {
+		if _, ok := kasas.transports[id].(*klap); !ok && inSubnets(a.IP, c.KasaSubnets) {
+			unicast = append(unicast, a.IP)
+		}
+	}
+	kasas.mu.Unlock()
+
 	for i := 0; i < broadcast_sends; i++ {
 		for _, b := range bcast {
 			err := sendUDP(b.String(), cmd)
@@ -411,6 +586,11 @@ func broadcastCmd(cmd string) error {
 				return err
 			}
 		}
+		for _, ip := range unicast {
+			if err := sendUDP(ip, cmd); err != nil {
+				log.Info.Println(err.Error())
+			}
+		}
 		time.Sleep(time.Second)
 	}
 	return nil
@@ -418,6 +598,16 @@ func broadcastCmd(cmd string) error {
 
 // Background runs a background Go task verifying HC has the current state of the Kasa devices
 func (k Platform) Background() {
+	if kdr := config.Get().KasaDiscoveryRate; kdr != 0 {
+		go func() {
+			for range time.Tick(time.Second * time.Duration(kdr)) {
+				if err := discover(); err != nil {
+					log.Info.Println(err.Error())
+				}
+			}
+		}()
+	}
+
 	kpr := config.Get().KasaPullRate
 	if kpr == 0 {
 		log.Info.Println("KasaPullRate is 0, disabling checks")
@@ -427,6 +617,9 @@ func (k Platform) Background() {
 		for range time.Tick(time.Second * time.Duration(kpr)) {
 			getSettingBroadcast()
 			getCountdownBroadcast()
+			pullKLAP()
+			getEmeterAll()
+			getCloudAll()
 		}
 	}()
 }
@@ -0,0 +1,206 @@
+package kasa
+
+import (
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/config"
+	"github.com/cloudkucooland/toofar/devices"
+	"github.com/cloudkucooland/toofar/kasasim"
+	"github.com/cloudkucooland/toofar/platform"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeHC stands in for the HomeControl platform
+type fakeHC struct{}
+
+func (h fakeHC) Startup(c *config.Config) platform.Control                 { return h }
+func (h fakeHC) Background()                                               {}
+func (h fakeHC) Shutdown() platform.Control                                { return h }
+func (h fakeHC) AddAccessory(a *tfaccessory.TFAccessory)                   {}
+func (h fakeHC) GetAccessory(name string) (*tfaccessory.TFAccessory, bool) { return nil, false }
+
+// the sim answers UDP on 9999 like a real device, on its own loopback address so it does not need the real port
+const simIP = "127.0.0.99"
+
+// startSimPlatform runs the kasa platform against the simulated device, the listener can only be started once per process
+func startSimPlatform(t *testing.T) *kasasim.Device {
+	sim, err := kasasim.New("HS103", "Sim Plug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Listen(simIP + ":9999"); err != nil {
+		t.Skipf("unable to simulate a device on %s: %s", simIP, err.Error())
+	}
+
+	// the platform keeps its AIDs on disk, in the working directory
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	conf := &config.Config{KasaInterfaces: []string{"lo"}, KasaSubnets: []string{simIP + "/32"}}
+	config.Set(conf)
+	platform.RegisterPlatform("HomeControl", fakeHC{})
+	platform.RegisterPlatform("Kasa", Platform{})
+	Platform{}.Startup(conf)
+	return sim
+}
+
+// testConfig is for tests which only need a config, the listener TestKasaSim starts keeps reading the one it set
+func testConfig() {
+	if config.Get() == nil {
+		config.Set(&config.Config{})
+	}
+}
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKasaSim(t *testing.T) {
+	sim := startSimPlatform(t)
+	defer sim.Close()
+
+	// Startup searched the subnet, the sim is not configured so it is only recorded
+	waitFor(t, "discovery", func() bool {
+		discovered.mu.Lock()
+		defer discovered.mu.Unlock()
+		d, ok := discovered.d[sim.DeviceID]
+		return ok && d.IP == simIP && d.Alias == "Sim Plug"
+	})
+
+	a := &tfaccessory.TFAccessory{Platform: "Kasa", IP: simIP, Name: "plug"}
+	Platform{}.AddAccessory(a)
+	hs, ok := a.Device.(*devices.HS103)
+	if !ok {
+		t.Fatalf("added as %T", a.Device)
+	}
+	discovered.mu.Lock()
+	if _, ok := discovered.d[sim.DeviceID]; ok {
+		t.Error("configured device still listed as discovered")
+	}
+	discovered.mu.Unlock()
+
+	t.Run("on and off", func(t *testing.T) {
+		for _, on := range []bool{true, false} {
+			if err := setRelayState(a, on); err != nil {
+				t.Fatal(err)
+			}
+			if sim.On() != on {
+				t.Errorf("sim is %t, want %t", sim.On(), on)
+			}
+		}
+	})
+
+	t.Run("button press", func(t *testing.T) {
+		sim.SetOn(true)
+		// the acknowledged path handles the response here rather than on the listener, hc values are not locked
+		if err := sendCmdAck(a, cmd_sysinfo); err != nil {
+			t.Fatal(err)
+		}
+		if !hs.Outlet.On.GetValue() {
+			t.Error("HomeKit did not follow the device")
+		}
+	})
+
+	t.Run("moved while down", func(t *testing.T) {
+		for _, tt := range []struct {
+			name       string
+			configured string // where the accessory config says it is
+			now        string // where it is
+			late       bool   // starts answering after the bridge gave up waiting
+		}{
+			{"found at startup", "127.0.0.97", "127.0.0.96", false},
+			{"answers later", "127.0.0.95", "127.0.0.94", true},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				moved, err := kasasim.New("HS103", "Sim "+tt.name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer moved.Close()
+				// found there last time
+				rememberConfig(&tfaccessory.TFAccessory{ConfigFile: tt.name + ".json"}, moved.DeviceID)
+
+				if !tt.late {
+					if err := moved.Listen(tt.now + ":9999"); err != nil {
+						t.Fatal(err)
+					}
+					sendUDP(tt.now, cmd_sysinfo)
+				}
+
+				m := &tfaccessory.TFAccessory{Platform: "Kasa", IP: tt.configured, Name: tt.name, ConfigFile: tt.name + ".json"}
+				start := time.Now()
+				Platform{}.AddAccessory(m)
+				if time.Since(start) >= moveWait {
+					t.Error("adding waited for the move")
+				}
+				WaitForMoves()
+
+				if tt.late {
+					if _, ok := byIP(tt.now); ok {
+						t.Fatal("added before it answered")
+					}
+					if err := moved.Listen(tt.now + ":9999"); err != nil {
+						t.Fatal(err)
+					}
+					sendUDP(tt.now, cmd_sysinfo)
+					waitFor(t, "the device to be reported", func() bool {
+						kasas.mu.Lock()
+						defer kasas.mu.Unlock()
+						_, ok := kasas.missing[moved.DeviceID]
+						return !ok
+					})
+					if _, ok := byIP(tt.now); ok {
+						t.Error("added after HomeKit started")
+					}
+					return
+				}
+				waitFor(t, "the device at its new address", func() bool {
+					a, ok := byIP(tt.now)
+					return ok && a == m
+				})
+				if err := setRelayState(m, true); err != nil {
+					t.Fatal(err)
+				}
+				if !moved.On() {
+					t.Error("sim at the new address did not turn on")
+				}
+			})
+		}
+	})
+
+	t.Run("klap", func(t *testing.T) {
+		ksim, err := kasasim.New("HS200", "Sim Switch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ksim.ListenKLAP("127.0.0.1:0", 2, "me@example.com", "secret"); err != nil {
+			t.Fatal(err)
+		}
+		defer ksim.Close()
+
+		k := &tfaccessory.TFAccessory{Platform: "Kasa", IP: ksim.KLAPAddr(), Name: "switch", KasaProtocol: "klap", Username: "me@example.com", Password: "secret"}
+		Platform{}.AddAccessory(k)
+		if _, ok := k.Device.(*devices.HS200); !ok {
+			t.Fatalf("added as %T", k.Device)
+		}
+		if !isKLAP(k) {
+			t.Fatal("not using klap")
+		}
+		if err := setRelayState(k, true); err != nil {
+			t.Fatal(err)
+		}
+		if !ksim.On() {
+			t.Error("sim did not turn on over klap")
+		}
+	})
+}
@@ -0,0 +1,284 @@
+package kasa
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/brutella/hc/log"
+	"github.com/cloudkucooland/toofar/config"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KLAP is the protocol newer firmware uses in place of the XOR protocol on port 9999
+// https://github.com/python-kasa/python-kasa/blob/master/kasa/klaptransport.py
+// a two-step handshake over HTTP proves both sides know the credentials and establishes a session,
+// each request is then AES-CBC encrypted with a key, IV and sequence number derived from the handshake
+
+// devices which have never been bound to a cloud account, or were set up with the app's defaults, use these
+const (
+	klapDefaultUsername = "kasa@tp-link.net"
+	klapDefaultPassword = "kasaSetup"
+)
+
+// the shortest a session is used before handshaking again
+const klapMinSession = 30 * time.Second
+
+type klap struct {
+	mu     sync.Mutex
+	host   string // ip, or ip:port
+	client *http.Client
+
+	// candidate auth hashes, tried in order during the handshake
+	credentials [][2]string
+
+	// set by the handshake
+	version  int
+	authHash []byte
+	cookie   string
+	expires  time.Time
+	key      []byte
+	iv       []byte
+	seq      int32
+	sig      []byte
+}
+
+func newKLAP(host, username, password string) *klap {
+	timeout := config.Get().KasaTimeout
+	if timeout <= 0 {
+		timeout = 10
+	}
+
+	k := klap{
+		host:   host,
+		client: &http.Client{Timeout: time.Second * time.Duration(timeout)},
+	}
+	if username != "" || password != "" {
+		k.credentials = append(k.credentials, [2]string{username, password})
+	}
+	k.credentials = append(k.credentials, [2]string{"", ""}, [2]string{klapDefaultUsername, klapDefaultPassword})
+	return &k
+}
+
+func md5sum(b ...[]byte) []byte {
+	h := md5.Sum(bytes.Join(b, nil))
+	return h[:]
+}
+
+func sha1sum(b ...[]byte) []byte {
+	h := sha1.Sum(bytes.Join(b, nil))
+	return h[:]
+}
+
+func sha256sum(b ...[]byte) []byte {
+	h := sha256.Sum256(bytes.Join(b, nil))
+	return h[:]
+}
+
+func klapAuthHash(version int, username, password string) []byte {
+	if version == 1 {
+		return md5sum(md5sum([]byte(username)), md5sum([]byte(password)))
+	}
+	return sha256sum(sha1sum([]byte(username)), sha1sum([]byte(password)))
+}
+
+func (k *klap) url(path string) string {
+	return fmt.Sprintf("http://%s/app/%s", k.host, path)
+}
+
+func (k *klap) post(url string, body []byte) (*http.Response, []byte, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	if k.cookie != "" {
+		req.Header.Set("Cookie", k.cookie)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp, nil, err
+	}
+	return resp, b, nil
+}
+
+// handshake establishes a new session
+func (k *klap) handshake() error {
+	k.cookie = ""
+	k.key = nil
+
+	local := make([]byte, 16)
+	if _, err := rand.Read(local); err != nil {
+		return err
+	}
+
+	resp, body, err := k.post(k.url("handshake1"), local)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK || len(body) != 48 {
+		return fmt.Errorf("klap handshake1 to %s failed: %s (%d bytes)", k.host, resp.Status, len(body))
+	}
+	remote := body[0:16]
+	serverHash := body[16:48]
+
+	// the cookie is TP_SESSIONID=xxx;TIMEOUT=86400 which net/http does not parse into something useful
+	timeout := 86400
+	for _, part := range strings.Split(resp.Header.Get("Set-Cookie"), ";") {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, "TP_SESSIONID=") {
+			k.cookie = part
+		}
+		if strings.HasPrefix(part, "TIMEOUT=") {
+			if t, err := strconv.Atoi(strings.TrimPrefix(part, "TIMEOUT=")); err == nil {
+				timeout = t
+			}
+		}
+	}
+
+	// find which version and which credentials the device is using
+	k.authHash = nil
+	for _, c := range k.credentials {
+		if h := klapAuthHash(2, c[0], c[1]); bytes.Equal(sha256sum(local, remote, h), serverHash) {
+			k.version, k.authHash = 2, h
+			break
+		}
+		if h := klapAuthHash(1, c[0], c[1]); bytes.Equal(sha256sum(local, h), serverHash) {
+			k.version, k.authHash = 1, h
+			break
+		}
+	}
+	if k.authHash == nil {
+		return fmt.Errorf("klap handshake to %s failed: credentials not accepted", k.host)
+	}
+
+	var payload []byte
+	if k.version == 1 {
+		payload = sha256sum(remote, k.authHash)
+	} else {
+		payload = sha256sum(remote, local, k.authHash)
+	}
+	resp, _, err = k.post(k.url("handshake2"), payload)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("klap handshake2 to %s failed: %s", k.host, resp.Status)
+	}
+
+	k.key = sha256sum([]byte("lsk"), local, remote, k.authHash)[:16]
+	ivseq := sha256sum([]byte("iv"), local, remote, k.authHash)
+	k.iv = ivseq[:12]
+	k.seq = int32(binary.BigEndian.Uint32(ivseq[28:32]))
+	k.sig = sha256sum([]byte("ldk"), local, remote, k.authHash)[:28]
+	k.expires = time.Now().Add(sessionLifetime(timeout))
+	log.Info.Printf("klap session established with %s (v%d)", k.host, k.version)
+	return nil
+}
+
+// sessionLifetime is how long to use a session the device keeps for timeout seconds
+// renew a quarter early, but not so often that every request handshakes, a device which drops it sooner gets a 403 and a new one
+func sessionLifetime(timeout int) time.Duration {
+	d := time.Duration(timeout) * time.Second * 3 / 4
+	if d < klapMinSession {
+		d = klapMinSession
+	}
+	return d
+}
+
+func (k *klap) ivFor(seq int32) []byte {
+	iv := make([]byte, 16)
+	copy(iv, k.iv)
+	binary.BigEndian.PutUint32(iv[12:], uint32(seq))
+	return iv
+}
+
+func (k *klap) encrypt(plaintext []byte, seq int32) ([]byte, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(plaintext, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, k.ivFor(seq)).CryptBlocks(ciphertext, padded)
+
+	s := make([]byte, 4)
+	binary.BigEndian.PutUint32(s, uint32(seq))
+	return append(sha256sum(k.sig, s, ciphertext), ciphertext...), nil
+}
+
+func (k *klap) decrypt(payload []byte, seq int32) ([]byte, error) {
+	if len(payload) < 32+aes.BlockSize || (len(payload)-32)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("klap response from %s is malformed (%d bytes)", k.host, len(payload))
+	}
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := payload[32:]
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, k.ivFor(seq)).CryptBlocks(plaintext, ciphertext)
+
+	pad := int(plaintext[len(plaintext)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, fmt.Errorf("klap response from %s has bad padding", k.host)
+	}
+	return plaintext[:len(plaintext)-pad], nil
+}
+
+// send satisfies the transport interface, re-authenticating if the session has expired or been dropped
+func (k *klap) send(cmd string) (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if k.key == nil || time.Now().After(k.expires) {
+			if err := k.handshake(); err != nil {
+				return "", err
+			}
+		}
+
+		k.seq++
+		seq := k.seq
+		payload, err := k.encrypt([]byte(cmd), seq)
+		if err != nil {
+			return "", err
+		}
+
+		resp, body, err := k.post(fmt.Sprintf("%s?seq=%d", k.url("request"), seq), payload)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized {
+			log.Info.Printf("klap session with %s rejected, re-authenticating", k.host)
+			k.key = nil
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("klap request to %s failed: %s", k.host, resp.Status)
+		}
+
+		res, err := k.decrypt(body, seq)
+		if err != nil {
+			return "", err
+		}
+		return string(res), nil
+	}
+	return "", fmt.Errorf("klap request to %s failed: unable to authenticate", k.host)
+}
@@ -0,0 +1,212 @@
+package kasa
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKLAP is a device on the KLAP protocol, doing its side of the crypto without the client's session code
+type fakeKLAP struct {
+	t        *testing.T
+	version  int
+	authHash []byte
+
+	mu         sync.Mutex
+	local      []byte
+	remote     []byte
+	authed     bool
+	handshakes int
+	requests   []string
+	dropNext   bool // answer the next request 403, as a device does after dropping the session
+}
+
+func newFakeKLAP(t *testing.T, version int, username, password string) *fakeKLAP {
+	return &fakeKLAP{t: t, version: version, authHash: klapAuthHash(version, username, password)}
+}
+
+func (f *fakeKLAP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	switch r.URL.Path {
+	case "/app/handshake1":
+		f.handshakes++
+		f.authed = false
+		f.local = body
+		f.remote = make([]byte, 16)
+		rand.Read(f.remote)
+		var serverHash []byte
+		if f.version == 1 {
+			serverHash = sha256sum(f.local, f.authHash)
+		} else {
+			serverHash = sha256sum(f.local, f.remote, f.authHash)
+		}
+		w.Header().Set("Set-Cookie", "TP_SESSIONID=fake;TIMEOUT=86400")
+		w.Write(append(append([]byte{}, f.remote...), serverHash...))
+	case "/app/handshake2":
+		var want []byte
+		if f.version == 1 {
+			want = sha256sum(f.remote, f.authHash)
+		} else {
+			want = sha256sum(f.remote, f.local, f.authHash)
+		}
+		if r.Header.Get("Cookie") != "TP_SESSIONID=fake" || !bytes.Equal(body, want) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		f.authed = true
+	case "/app/request":
+		if !f.authed || f.dropNext {
+			f.dropNext = false
+			f.authed = false
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		seq, err := strconv.Atoi(r.URL.Query().Get("seq"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		key := sha256sum([]byte("lsk"), f.local, f.remote, f.authHash)[:16]
+		ivseq := sha256sum([]byte("iv"), f.local, f.remote, f.authHash)
+		sig := sha256sum([]byte("ldk"), f.local, f.remote, f.authHash)[:28]
+		iv := make([]byte, 16)
+		copy(iv, ivseq[:12])
+		binary.BigEndian.PutUint32(iv[12:], uint32(seq))
+		s := make([]byte, 4)
+		binary.BigEndian.PutUint32(s, uint32(seq))
+
+		if len(body) < 48 || !bytes.Equal(body[:32], sha256sum(sig, s, body[32:])) {
+			f.t.Errorf("request signature does not match")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		block, _ := aes.NewCipher(key)
+		plain := make([]byte, len(body)-32)
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, body[32:])
+		plain = plain[:len(plain)-int(plain[len(plain)-1])]
+		f.requests = append(f.requests, string(plain))
+
+		res := []byte(`{"system":{"get_sysinfo":{"alias":"fake","err_code":0}}}`)
+		pad := aes.BlockSize - len(res)%aes.BlockSize
+		res = append(res, bytes.Repeat([]byte{byte(pad)}, pad)...)
+		out := make([]byte, len(res))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, res)
+		w.Write(append(sha256sum(sig, s, out), out...))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestKLAPRoundTrip(t *testing.T) {
+	testConfig()
+
+	tests := []struct {
+		name     string
+		version  int
+		device   [2]string // the credentials the device was set up with
+		username string    // what the accessory config has
+		password string
+	}{
+		{"v2 account", 2, [2]string{"me@example.com", "secret"}, "me@example.com", "secret"},
+		{"v2 defaults", 2, [2]string{klapDefaultUsername, klapDefaultPassword}, "", ""},
+		{"v2 never bound", 2, [2]string{"", ""}, "me@example.com", "secret"},
+		{"v1 account", 1, [2]string{"me@example.com", "secret"}, "me@example.com", "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeKLAP(t, tt.version, tt.device[0], tt.device[1])
+			srv := httptest.NewServer(f)
+			defer srv.Close()
+
+			k := newKLAP(strings.TrimPrefix(srv.URL, "http://"), tt.username, tt.password)
+			for i := 0; i < 2; i++ {
+				res, err := k.send(cmd_sysinfo)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(res, `"alias":"fake"`) {
+					t.Errorf("unexpected response: %s", res)
+				}
+			}
+			if k.version != tt.version {
+				t.Errorf("negotiated v%d, want v%d", k.version, tt.version)
+			}
+			if f.handshakes != 1 {
+				t.Errorf("%d handshakes, the session should be reused", f.handshakes)
+			}
+			if len(f.requests) != 2 || f.requests[0] != cmd_sysinfo {
+				t.Errorf("device received %q", f.requests)
+			}
+		})
+	}
+}
+
+func TestKLAPWrongCredentials(t *testing.T) {
+	testConfig()
+
+	f := newFakeKLAP(t, 2, "me@example.com", "secret")
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	k := newKLAP(strings.TrimPrefix(srv.URL, "http://"), "me@example.com", "wrong")
+	if _, err := k.send(cmd_sysinfo); err == nil {
+		t.Fatal("handshake with the wrong password succeeded")
+	}
+	if len(f.requests) != 0 {
+		t.Errorf("request sent without a session: %q", f.requests)
+	}
+}
+
+func TestKLAPReauthenticates(t *testing.T) {
+	testConfig()
+
+	f := newFakeKLAP(t, 2, "", "")
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	k := newKLAP(strings.TrimPrefix(srv.URL, "http://"), "", "")
+	if _, err := k.send(cmd_sysinfo); err != nil {
+		t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	f.dropNext = true
+	f.mu.Unlock()
+	if _, err := k.send(cmd_sysinfo); err != nil {
+		t.Fatal(err)
+	}
+	if f.handshakes != 2 {
+		t.Errorf("%d handshakes, want a new session after the 403", f.handshakes)
+	}
+}
+
+func TestSessionLifetime(t *testing.T) {
+	tests := []struct {
+		timeout int
+		want    time.Duration
+	}{
+		{86400, 18 * time.Hour},
+		{1200, 15 * time.Minute},
+		{600, 450 * time.Second},
+		{20, klapMinSession},
+		{0, klapMinSession},
+	}
+	for _, tt := range tests {
+		if got := sessionLifetime(tt.timeout); got != tt.want {
+			t.Errorf("TIMEOUT=%d: %s, want %s", tt.timeout, got, tt.want)
+		}
+	}
+}
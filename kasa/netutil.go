@@ -1,11 +1,16 @@
 package kasa
 
 import (
+	"fmt"
 	"net"
 	"strings"
 )
 
-func broadcastAddresses() ([]net.IP, error) {
+// the largest subnet which will be searched one address at a time
+const maxSweepHosts = 4096
+
+// broadcastAddresses lists the IPv4 broadcast address of each interface, or only the named interfaces if any are given
+func broadcastAddresses(names []string) ([]net.IP, error) {
 	var broadcasts []net.IP
 	ifaces, err := net.Interfaces()
 	if err != nil {
@@ -13,6 +18,13 @@ func broadcastAddresses() ([]net.IP, error) {
 	}
 
 	for _, i := range ifaces {
+		if len(names) > 0 && !contains(names, i.Name) {
+			continue
+		}
+		if i.Flags&net.FlagUp == 0 {
+			continue
+		}
+
 		addrs, err := i.Addrs()
 		if err != nil {
 			return broadcasts, err
@@ -37,3 +49,55 @@ func broadcastAddresses() ([]net.IP, error) {
 	}
 	return broadcasts, nil
 }
+
+// subnetHosts lists every usable address in an IPv4 CIDR
+func subnetHosts(cidr string) ([]net.IP, error) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	base := ipnet.IP.To4()
+	if base == nil {
+		return nil, fmt.Errorf("kasa devices are IPv4 only: %s", cidr)
+	}
+	ones, bits := ipnet.Mask.Size()
+	size := 1 << uint(bits-ones)
+	if size > maxSweepHosts {
+		return nil, fmt.Errorf("subnet too large to search: %s", cidr)
+	}
+
+	start := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8 | uint32(base[3])
+	var hosts []net.IP
+	for i := 0; i < size; i++ {
+		// skip the network and broadcast addresses, except on /31 and /32
+		if size > 2 && (i == 0 || i == size-1) {
+			continue
+		}
+		n := start + uint32(i)
+		hosts = append(hosts, net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n)))
+	}
+	return hosts, nil
+}
+
+// inSubnets reports if the address is in any of the CIDRs
+func inSubnets(ip string, cidrs []string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, c := range cidrs {
+		if _, ipnet, err := net.ParseCIDR(c); err == nil && ipnet.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package kasa
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/brutella/hc/log"
+)
+
+// new devices come up as an access point and answer on this address
+const SetupIP = "192.168.0.1"
+
+// ProvisionSettings is what is needed to join a device in setup mode to the local network
+type ProvisionSettings struct {
+	SSID     string
+	Password string
+	KeyType  int    // 0 to use what the device sees the network advertising
+	Alias    string // unset to leave the default
+	Unbind   bool   // remove any cloud binding
+}
+
+// ScanWifi asks a device in setup mode which networks it can see
+func ScanWifi(ip string) ([]AccessPoint, error) {
+	res, err := sendTCP(ip, `{"netif":{"get_scaninfo":{"refresh":1}}}`)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkErrCode(res); err != nil {
+		return nil, err
+	}
+
+	var kd kasaDevice
+	if err := json.Unmarshal([]byte(res), &kd); err != nil {
+		return nil, err
+	}
+	return kd.Netif.ScanInfo.APList, nil
+}
+
+// Provision joins a device in setup mode to a network, setting the alias and removing cloud binding first
+// since the device leaves setup mode as soon as it has the network settings
+func Provision(ip string, p ProvisionSettings) error {
+	if p.SSID == "" {
+		return fmt.Errorf("SSID required")
+	}
+
+	if p.KeyType == 0 && p.Password != "" {
+		aps, err := ScanWifi(ip)
+		if err != nil {
+			return err
+		}
+		for _, ap := range aps {
+			if ap.SSID == p.SSID {
+				p.KeyType = ap.KeyType
+			}
+		}
+		if p.KeyType == 0 {
+			return fmt.Errorf("device does not see %s, set the key type to join it anyway", p.SSID)
+		}
+	}
+
+	if p.Alias != "" {
+		cmd, _ := json.Marshal(map[string]interface{}{
+			"system": map[string]interface{}{"set_alias": map[string]string{"alias": p.Alias}},
+		})
+		if err := provisionCmd(ip, string(cmd)); err != nil {
+			return err
+		}
+	}
+
+	if p.Unbind {
+		// devices which were never bound say so, that is not a problem
+		if err := provisionCmd(ip, `{"cnCloud":{"unbind":{}}}`); err != nil {
+			log.Info.Printf("cloud unbind: %s", err.Error())
+		}
+	}
+
+	cmd, err := json.Marshal(map[string]interface{}{
+		"netif": map[string]interface{}{
+			"set_stainfo": map[string]interface{}{"ssid": p.SSID, "password": p.Password, "key_type": p.KeyType},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return provisionCmd(ip, string(cmd))
+}
+
+func provisionCmd(ip, cmd string) error {
+	res, err := sendTCP(ip, cmd)
+	if err != nil {
+		return err
+	}
+	return checkErrCode(res)
+}
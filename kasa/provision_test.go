@@ -0,0 +1,157 @@
+package kasa
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSetupDevice is a device in setup mode on a loopback port, recording the commands it is sent
+type fakeSetupDevice struct {
+	l       net.Listener
+	mu      sync.Mutex
+	methods []string
+	sent    []string
+	unbind  string // the unbind response, devices which were never bound return an error
+}
+
+func newFakeSetupDevice(t *testing.T) *fakeSetupDevice {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSetupDevice{l: l, unbind: `{"cnCloud":{"unbind":{"err_code":0}}}`}
+	go f.serve()
+	return f
+}
+
+func (f *fakeSetupDevice) serve() {
+	for {
+		conn, err := f.l.Accept()
+		if err != nil {
+			return
+		}
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			conn.Close()
+			continue
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			conn.Close()
+			continue
+		}
+		conn.Write(encryptTCP(f.answer(decrypt(payload))))
+		conn.Close()
+	}
+}
+
+func (f *fakeSetupDevice) answer(cmd string) string {
+	var req map[string]map[string]json.RawMessage
+	json.Unmarshal([]byte(cmd), &req)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, cmd)
+	for module, methods := range req {
+		for method := range methods {
+			f.methods = append(f.methods, module+"."+method)
+			switch method {
+			case "get_scaninfo":
+				return `{"netif":{"get_scaninfo":{"ap_list":[{"ssid":"other","key_type":2},{"ssid":"home","key_type":3}],"err_code":0}}}`
+			case "unbind":
+				return f.unbind
+			default:
+				return `{"` + module + `":{"` + method + `":{"err_code":0}}}`
+			}
+		}
+	}
+	return `{"err_code":-1,"err_msg":"json decode error"}`
+}
+
+func TestScanWifi(t *testing.T) {
+	testConfig()
+	f := newFakeSetupDevice(t)
+	defer f.l.Close()
+
+	aps, err := ScanWifi(f.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aps) != 2 || aps[1].SSID != "home" || aps[1].KeyType != 3 {
+		t.Errorf("unexpected access points: %+v", aps)
+	}
+}
+
+func TestProvision(t *testing.T) {
+	testConfig()
+
+	tests := []struct {
+		name    string
+		p       ProvisionSettings
+		unbind  string
+		methods []string
+		keyType string // in the set_stainfo command
+		fails   bool
+	}{
+		{
+			name:    "alias, unbind, then join",
+			p:       ProvisionSettings{SSID: "home", Password: "pw", Alias: "Lamp", Unbind: true},
+			methods: []string{"netif.get_scaninfo", "system.set_alias", "cnCloud.unbind", "netif.set_stainfo"},
+			keyType: `"key_type":3`,
+		},
+		{
+			name:    "never bound",
+			p:       ProvisionSettings{SSID: "home", Password: "pw", KeyType: 2, Unbind: true},
+			unbind:  `{"cnCloud":{"unbind":{"err_code":-1,"err_msg":"not bound"}}}`,
+			methods: []string{"cnCloud.unbind", "netif.set_stainfo"},
+			keyType: `"key_type":2`,
+		},
+		{
+			name:    "open network",
+			p:       ProvisionSettings{SSID: "cafe"},
+			methods: []string{"netif.set_stainfo"},
+			keyType: `"key_type":0`,
+		},
+		{
+			name:    "network not seen",
+			p:       ProvisionSettings{SSID: "away", Password: "pw", Alias: "Lamp"},
+			methods: []string{"netif.get_scaninfo"},
+			fails:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeSetupDevice(t)
+			defer f.l.Close()
+			if tt.unbind != "" {
+				f.unbind = tt.unbind
+			}
+
+			err := Provision(f.l.Addr().String(), tt.p)
+			if tt.fails != (err != nil) {
+				t.Fatalf("unexpected result: %v", err)
+			}
+
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if strings.Join(f.methods, " ") != strings.Join(tt.methods, " ") {
+				t.Errorf("sent %v, want %v", f.methods, tt.methods)
+			}
+			if tt.fails {
+				return
+			}
+			last := f.sent[len(f.sent)-1]
+			if !strings.Contains(last, tt.keyType) || !strings.Contains(last, `"ssid":"`+tt.p.SSID+`"`) {
+				t.Errorf("unexpected set_stainfo: %s", last)
+			}
+			if tt.p.Alias != "" && !strings.Contains(strings.Join(f.sent, " "), `"alias":"`+tt.p.Alias+`"`) {
+				t.Errorf("alias not sent: %v", f.sent)
+			}
+		})
+	}
+}
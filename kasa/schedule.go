@@ -0,0 +1,197 @@
+package kasa
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/brutella/hc/log"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+)
+
+// the device-local rule modules; TooFar does automation in HomeKit, these are here to audit and purge what the vendor app left behind
+const (
+	Schedule = "schedule"
+	AwayMode = "anti_theft"
+)
+
+// ruleCmd sends a rule command to the device at ip, directed at a child outlet if child is set
+// known devices use their configured transport, anything else (e.g. from the command line) gets xor
+func ruleCmd(ip, child, module, method string, arg interface{}) (*kasaDevice, error) {
+	if module != Schedule && module != AwayMode {
+		return nil, fmt.Errorf("unknown rule module: %s", module)
+	}
+
+	req := map[string]interface{}{
+		module: map[string]interface{}{method: arg},
+	}
+	if child != "" {
+		req["context"] = map[string][]string{"child_ids": {child}}
+	}
+	cmd, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	kasas.mu.Lock()
+	a, known := kasas.ks[kasas.ips[ip]]
+	kasas.mu.Unlock()
+	var t transport = xor{ip: ip}
+	if known {
+		t = transportFor(a)
+	}
+
+	res, err := t.send(string(cmd))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkErrCode(res); err != nil {
+		return nil, err
+	}
+
+	var kd kasaDevice
+	if err := json.Unmarshal([]byte(res), &kd); err != nil {
+		return nil, err
+	}
+	return &kd, nil
+}
+
+func (kd *kasaDevice) rules(module string) *scheduleModule {
+	if module == AwayMode {
+		return &kd.AntiTheft
+	}
+	return &kd.Schedule
+}
+
+// Rules lists the schedule or away mode rules stored on a device
+func Rules(ip, child, module string) ([]ScheduleRule, error) {
+	kd, err := ruleCmd(ip, child, module, "get_rules", struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	return kd.rules(module).GetRules.RuleList, nil
+}
+
+// AddRule stores a new rule on the device and returns the ID the device assigned it
+func AddRule(ip, child, module string, r ScheduleRule) (string, error) {
+	r.ID = ""
+	kd, err := ruleCmd(ip, child, module, "add_rule", r)
+	if err != nil {
+		return "", err
+	}
+	return kd.rules(module).AddRule.ID, nil
+}
+
+// EditRule replaces the rule with the same ID
+func EditRule(ip, child, module string, r ScheduleRule) error {
+	if r.ID == "" {
+		return fmt.Errorf("rule ID required")
+	}
+	_, err := ruleCmd(ip, child, module, "edit_rule", r)
+	return err
+}
+
+// findRule returns the rule with the ID, nil if the device has none
+func findRule(ip, child, module, id string) (*ScheduleRule, error) {
+	rules, err := Rules(ip, child, module)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		if r.ID == id {
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+// DeleteRule removes a single rule
+func DeleteRule(ip, child, module, id string) error {
+	_, err := ruleCmd(ip, child, module, "delete_rule", map[string]string{"id": id})
+	return err
+}
+
+// DeleteAllRules removes every rule in the module
+func DeleteAllRules(ip, child, module string) error {
+	_, err := ruleCmd(ip, child, module, "delete_all_rules", struct{}{})
+	return err
+}
+
+// RulesHandler is registered with the HTTP platform
+// GET lists, POST adds, PUT (with an ID) edits, DELETE removes one (with an ID) or all rules
+// set ?child= to target a single outlet of a strip
+func RulesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ip := vars["device"]
+	module := vars["module"]
+	id := vars["id"]
+	child := r.URL.Query().Get("child")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// only devices the bridge knows, this is not a way to send commands to any host on the network
+	if _, ok := byIP(ip); !ok {
+		http.Error(w, `{ "status": "unknown device" }`, http.StatusNotFound)
+		return
+	}
+
+	var rule ScheduleRule
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, `{ "status": "unable to read request" }`, http.StatusBadRequest)
+			return
+		}
+
+		// a PUT changes only the fields it sends, start from the rule as stored
+		if r.Method == http.MethodPut && id != "" {
+			existing, err := findRule(ip, child, module, id)
+			if err != nil {
+				log.Info.Printf("kasa %s on %s: %s", module, ip, err.Error())
+				http.Error(w, fmt.Sprintf(`{ "status": %q }`, err.Error()), http.StatusBadGateway)
+				return
+			}
+			if existing == nil {
+				http.Error(w, `{ "status": "unknown rule" }`, http.StatusNotFound)
+				return
+			}
+			rule = *existing
+		}
+		if err := json.Unmarshal(body, &rule); err != nil {
+			http.Error(w, `{ "status": "unable to parse rule" }`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	var out interface{}
+	var err error
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		out, err = Rules(ip, child, module)
+	case r.Method == http.MethodPost && id == "":
+		var newID string
+		newID, err = AddRule(ip, child, module, rule)
+		out = map[string]string{"id": newID}
+	case r.Method == http.MethodPut && id != "":
+		rule.ID = id
+		err = EditRule(ip, child, module, rule)
+	case r.Method == http.MethodDelete && id != "":
+		err = DeleteRule(ip, child, module, id)
+	case r.Method == http.MethodDelete:
+		err = DeleteAllRules(ip, child, module)
+	default:
+		http.Error(w, `{ "status": "unsupported method" }`, http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		log.Info.Printf("kasa %s on %s: %s", module, ip, err.Error())
+		http.Error(w, fmt.Sprintf(`{ "status": %q }`, err.Error()), http.StatusBadGateway)
+		return
+	}
+
+	if out == nil {
+		out = map[string]string{"status": "OK"}
+	}
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		log.Info.Println(err.Error())
+	}
+}
@@ -0,0 +1,82 @@
+package kasa
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/log"
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/devices"
+)
+
+// countdowns on strips live on the children, and the responses do not say which child they are for,
+// so they are pulled from each child directly rather than through the listener
+
+func deleteChildCountdown(a *tfaccessory.TFAccessory, childID string) error {
+	res, err := transportFor(a).send(fmt.Sprintf(`{"context":{"child_ids":["%s"]},"count_down":{"delete_all_rules":{}}}`, childID))
+	if err != nil {
+		log.Info.Println(err.Error())
+		return err
+	}
+	return checkErrCode(res)
+}
+
+// getStripCountdowns is called when a strip answers the count_down broadcast
+func getStripCountdowns(a *tfaccessory.TFAccessory) {
+	ks, ok := a.Device.(*devices.KasaStrip)
+	if !ok {
+		return
+	}
+
+	for _, outlet := range ks.Outlets {
+		res, err := transportFor(a).send(fmt.Sprintf(`{"context":{"child_ids":["%s"]},"count_down":{"get_rules":{}}}`, outlet.ChildID))
+		if err != nil {
+			log.Info.Println(err.Error())
+			continue
+		}
+		kd := kasaDevice{}
+		if err := json.Unmarshal([]byte(res), &kd); err != nil {
+			log.Info.Println(err.Error())
+			continue
+		}
+		if kd.Countdown.GetRules.ErrorCode != 0 {
+			log.Info.Printf("[%s] count_down.get_rules failed: %s", outlet.ChildID, kd.Countdown.GetRules.ErrorMessage)
+			continue
+		}
+		updateChildCountdown(a, outlet, kd.Countdown.GetRules.RuleList)
+	}
+}
+
+// updateChildCountdown is the per-outlet version of the count_down.get_rules handling in doUDPresponse
+func updateChildCountdown(a *tfaccessory.TFAccessory, outlet *devices.KasaStripOutlet, rules []rule) {
+	var active bool
+	var remaining int
+	for _, r := range rules {
+		if r.Enable != 0 {
+			active = true
+			remaining = int(r.Remaining)
+			break
+		}
+	}
+
+	// expired rules are left behind, purge them
+	if !active && len(rules) != 0 {
+		if err := deleteChildCountdown(a, outlet.ChildID); err != nil {
+			log.Info.Println(err.Error())
+		}
+	}
+
+	ps := outlet.ProgramMode.GetValue()
+	if !active && ps == characteristic.ProgramModeProgramScheduled {
+		outlet.ProgramMode.SetValue(characteristic.ProgramModeNoProgramScheduled)
+		outlet.RemainingDuration.SetValue(0)
+		outlet.SetDuration.SetValue(0)
+	}
+	if active && ps == characteristic.ProgramModeNoProgramScheduled {
+		outlet.ProgramMode.SetValue(characteristic.ProgramModeProgramScheduled)
+		outlet.SetDuration.SetValue(0)
+	}
+	if remaining != 0 {
+		outlet.RemainingDuration.SetValue(remaining)
+	}
+}
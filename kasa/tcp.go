@@ -4,16 +4,16 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"github.com/brutella/hc/log"
-	tfaccessory "github.com/cloudkucooland/toofar/accessory"
 	"github.com/cloudkucooland/toofar/config"
+	"io"
 	"net"
 	"time"
 )
 
-func getSettingsTCP(a *tfaccessory.TFAccessory) (*ksysinfo, error) {
-	// log.Info.Printf("full kasa pull for [%s]", a.Name)
-	res, err := sendTCP(a.IP, cmd_sysinfo)
+func getSettings(t transport) (*ksysinfo, error) {
+	res, err := t.send(cmd_sysinfo)
 	if err != nil {
 		log.Info.Println(err.Error())
 		return nil, err
@@ -56,12 +56,14 @@ func sendTCP(ip string, cmd string) (string, error) {
 		timeout = 10
 	}
 	payload := encryptTCP(cmd)
-	r := net.TCPAddr{
-		IP:   net.ParseIP(ip),
-		Port: 9999,
+
+	// ip:port is accepted for talking to simulators and test fixtures
+	addr := net.JoinHostPort(ip, "9999")
+	if _, _, err := net.SplitHostPort(ip); err == nil {
+		addr = ip
 	}
 
-	conn, err := net.DialTCP("tcp4", nil, &r)
+	conn, err := net.DialTimeout("tcp4", addr, time.Second*time.Duration(timeout))
 	if err != nil {
 		log.Info.Printf("Cannot connnect to device: %s", err.Error())
 		return "", err
@@ -74,16 +76,23 @@ func sendTCP(ip string, cmd string) (string, error) {
 		return "", err
 	}
 
-	// HS200's return ~600 bytes, HS220's return ~800 bytes; 1k should be enough
-	// KP103s return larger packets, bump this to 1500 (normal wifi mtu)
-	// if we need more, we need to build a buffer and fill it over multiple reads
-	// see go-eiscp's method for how to improve this
-	data := make([]byte, 1500)
-	n, err := conn.Read(data)
-	if err != nil {
+	// responses are prefixed with the length; emeter stats and strips can be several kilobytes
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		log.Info.Println("Cannot read data from device:", err)
+		return "", err
+	}
+	n := binary.BigEndian.Uint32(header)
+	if n > 65536 {
+		err := fmt.Errorf("response too large: %d bytes", n)
+		log.Info.Println(err.Error())
+		return "", err
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(conn, data); err != nil {
 		log.Info.Println("Cannot read data from device:", err)
 		return "", err
 	}
-	result := decrypt(data[4:n]) // start reading at 4, go to total bytes read
+	result := decrypt(data)
 	return result, nil
 }
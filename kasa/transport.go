@@ -0,0 +1,79 @@
+package kasa
+
+import (
+	"fmt"
+	"github.com/brutella/hc/log"
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+)
+
+// transport is a request/response path to a single device
+type transport interface {
+	send(cmd string) (string, error)
+}
+
+// xor is the legacy protocol over TCP port 9999
+type xor struct {
+	ip string
+}
+
+func (x xor) send(cmd string) (string, error) {
+	return sendTCP(x.ip, cmd)
+}
+
+// transportFor returns the transport chosen for the device when it was added, defaulting to xor
+func transportFor(a *tfaccessory.TFAccessory) transport {
+	kasas.mu.Lock()
+	t, ok := kasas.transports[a.Info.SerialNumber]
+	kasas.mu.Unlock()
+	if !ok {
+		return xor{ip: ipOf(a)}
+	}
+	return t
+}
+
+func isKLAP(a *tfaccessory.TFAccessory) bool {
+	_, ok := transportFor(a).(*klap)
+	return ok
+}
+
+// detectTransport uses the protocol set in the accessory config, or tries xor then klap if unset
+func detectTransport(a *tfaccessory.TFAccessory) (transport, error) {
+	switch a.KasaProtocol {
+	case "xor":
+		return xor{ip: a.IP}, nil
+	case "klap":
+		return newKLAP(a.IP, a.Username, a.Password), nil
+	case "":
+		// below
+	default:
+		return nil, fmt.Errorf("unknown kasa protocol: %s", a.KasaProtocol)
+	}
+
+	x := xor{ip: a.IP}
+	if _, err := x.send(cmd_sysinfo); err == nil {
+		return x, nil
+	}
+
+	k := newKLAP(a.IP, a.Username, a.Password)
+	if _, err := k.send(cmd_sysinfo); err != nil {
+		return nil, fmt.Errorf("%s does not respond to xor or klap: %s", a.IP, err.Error())
+	}
+	log.Info.Printf("using klap for %s", a.IP)
+	return k, nil
+}
+
+// sendCmd sends a command to a device without waiting for it to be processed
+// xor devices get it over UDP and the response is handled by the listener thread,
+// klap devices do not listen on UDP so it is sent over the session and the response is handled here
+func sendCmd(a *tfaccessory.TFAccessory, cmd string) error {
+	if !isKLAP(a) {
+		return sendUDP(ipOf(a), cmd)
+	}
+
+	res, err := transportFor(a).send(cmd)
+	if err != nil {
+		return err
+	}
+	doUDPresponse(ipOf(a), res)
+	return nil
+}
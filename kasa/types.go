@@ -1,10 +1,18 @@
 package kasa
 
+import (
+	"strings"
+)
+
 // defined by kasa devices
 type kasaDevice struct {
-	System    ksystem   `json:"system"`
-	Countdown countdown `json:"count_down"`
-	// emeter stuff?
+	System    ksystem        `json:"system"`
+	Countdown countdown      `json:"count_down"`
+	Emeter    emeter         `json:"emeter"`
+	Lighting  lighting       `json:"smartlife.iot.smartbulb.lightingservice"`
+	Schedule  scheduleModule `json:"schedule"`
+	AntiTheft scheduleModule `json:"anti_theft"`
+	Netif     netif          `json:"netif"`
 }
 
 // defined by kasa devices
@@ -26,16 +34,43 @@ type ksysinfo struct {
 	Alias      string  `json:"alias"`
 	Status     string  `json:"status"`
 	MIC        string  `json:"mic_type"`
+	Type       string  `json:"type"` // older firmware uses this instead of mic_type
 	Feature    string  `json:"feature"`
 	MAC        string  `json:"mac"`
-	Updating   int     `json""updating"`
+	Updating   int     `json:"updating"`
 	LEDOff     int     `json:"led_off"`
 	RelayState int     `json:"relay_state"`
-	Brightness int     `json:"brightness"`
+	Brightness *int    `json:"brightness"` // only present on dimmers
 	OnTime     int     `json:"on_time"`
 	ActiveMode string  `json:"active_mode"`
 	DevName    string  `json:"dev_name"`
 	Children   []child `json:"children"`
+
+	// bulbs
+	Description         string      `json:"description"`
+	MicMAC              string      `json:"mic_mac"`
+	LightState          *lightState `json:"light_state"`
+	IsDimmable          int         `json:"is_dimmable"`
+	IsColor             int         `json:"is_color"`
+	IsVariableColorTemp int         `json:"is_variable_color_temp"`
+}
+
+// micType is the device class, older firmware reports it as "type"
+func (s *ksysinfo) micType() string {
+	if s.MIC != "" {
+		return s.MIC
+	}
+	return s.Type
+}
+
+// hasFeature checks the feature list (e.g. "TIM:ENE") for a capability
+func (s *ksysinfo) hasFeature(f string) bool {
+	for _, v := range strings.Split(s.Feature, ":") {
+		if v == f {
+			return true
+		}
+	}
+	return false
 }
 
 type child struct {
@@ -76,3 +111,121 @@ type addRule struct {
 	ErrorCode    int8   `json:"err_code"`
 	ErrorMessage string `json:"err_msg"`
 }
+
+type emeter struct {
+	Realtime  realtime  `json:"get_realtime"`
+	Daystat   daystat   `json:"get_daystat"`
+	Monthstat monthstat `json:"get_monthstat"`
+}
+
+// newer (v2+) hardware reports milli-units, older hardware reports base units
+type realtime struct {
+	CurrentMA    float64 `json:"current_ma"`
+	VoltageMV    float64 `json:"voltage_mv"`
+	PowerMW      float64 `json:"power_mw"`
+	TotalWH      float64 `json:"total_wh"`
+	Current      float64 `json:"current"`
+	Voltage      float64 `json:"voltage"`
+	Power        float64 `json:"power"`
+	Total        float64 `json:"total"`
+	ErrorCode    int8    `json:"err_code"`
+	ErrorMessage string  `json:"err_msg"`
+}
+
+type daystat struct {
+	DayList      []energyStat `json:"day_list"`
+	ErrorCode    int8         `json:"err_code"`
+	ErrorMessage string       `json:"err_msg"`
+}
+
+type monthstat struct {
+	MonthList    []energyStat `json:"month_list"`
+	ErrorCode    int8         `json:"err_code"`
+	ErrorMessage string       `json:"err_msg"`
+}
+
+type energyStat struct {
+	Year     int     `json:"year"`
+	Month    int     `json:"month"`
+	Day      int     `json:"day"`
+	EnergyWH float64 `json:"energy_wh"`
+	Energy   float64 `json:"energy"` // kWh, older hardware
+}
+
+type lighting struct {
+	TransitionLightState lightState `json:"transition_light_state"`
+}
+
+// used both for reporting state and, with only the fields to change set, for changing it
+type lightState struct {
+	OnOff        *int        `json:"on_off,omitempty"`
+	Mode         string      `json:"mode,omitempty"`
+	Hue          *int        `json:"hue,omitempty"`
+	Saturation   *int        `json:"saturation,omitempty"`
+	ColorTemp    *int        `json:"color_temp,omitempty"`
+	Brightness   *int        `json:"brightness,omitempty"`
+	Transition   uint32      `json:"transition_period,omitempty"`
+	DftOnState   *lightState `json:"dft_on_state,omitempty"`
+	ErrorCode    int8        `json:"err_code,omitempty"`
+	ErrorMessage string      `json:"err_msg,omitempty"`
+}
+
+// current fills in the light values from dft_on_state when the bulb is off, since they are not sent at the top level
+func (l *lightState) current() *lightState {
+	if l.DftOnState == nil {
+		return l
+	}
+	c := *l.DftOnState
+	c.OnOff = l.OnOff
+	return &c
+}
+
+// ScheduleRule is the format of both the schedule and anti_theft (away mode) rules stored on the device
+type ScheduleRule struct {
+	ID        string  `json:"id,omitempty"`
+	Name      string  `json:"name"`
+	Enable    uint8   `json:"enable"`
+	WDay      []uint8 `json:"wday"`      // 7 entries, Sunday first, 1 if the rule runs that day
+	Repeat    uint8   `json:"repeat"`    // 0 for one-time rules
+	StimeOpt  int8    `json:"stime_opt"` // 0 at smin, 1 sunrise, 2 sunset
+	SMin      uint16  `json:"smin"`      // minutes after midnight
+	SAct      int8    `json:"sact"`      // 0 off, 1 on
+	EtimeOpt  int8    `json:"etime_opt"` // -1 for no end action
+	EMin      uint16  `json:"emin"`
+	EAct      int8    `json:"eact"`
+	Year      int     `json:"year,omitempty"`
+	Month     int     `json:"month,omitempty"`
+	Day       int     `json:"day,omitempty"`
+	Frequency uint8   `json:"frequency,omitempty"` // away mode only: how often to toggle
+	Force     uint8   `json:"force,omitempty"`
+	Latitude  int     `json:"latitude,omitempty"`
+	Longitude int     `json:"longitude,omitempty"`
+}
+
+type scheduleModule struct {
+	GetRules scheduleRules `json:"get_rules"`
+	AddRule  addRule       `json:"add_rule"`
+}
+
+type scheduleRules struct {
+	RuleList     []ScheduleRule `json:"rule_list"`
+	Enable       uint8          `json:"enable"`
+	ErrorCode    int8           `json:"err_code"`
+	ErrorMessage string         `json:"err_msg"`
+}
+
+type netif struct {
+	ScanInfo scanInfo `json:"get_scaninfo"`
+}
+
+type scanInfo struct {
+	APList       []AccessPoint `json:"ap_list"`
+	ErrorCode    int8          `json:"err_code"`
+	ErrorMessage string        `json:"err_msg"`
+}
+
+// AccessPoint is a network seen by a device in setup mode
+type AccessPoint struct {
+	SSID    string `json:"ssid"`
+	KeyType int    `json:"key_type"` // 0 open, 1 WEP, 2 WPA, 3 WPA2
+}
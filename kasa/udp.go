@@ -26,10 +26,17 @@ func doUDPresponse(ip, res string) {
 	}
 	a, ok := k.GetAccessory(ip)
 	if !ok {
-		if !config.Get().Discover {
+		a, ok = claimResponse(ip, res)
+	}
+	if !ok {
+		if reportMissing(ip, res) {
 			return
 		}
-		if _, ok := kasas.ignore[ip]; ok {
+		if ignored(ip) {
+			return
+		}
+		recordDiscovered(ip, res)
+		if !config.Get().Discover {
 			return
 		}
 		log.Info.Printf("adding previously unknown device: %s", ip)
@@ -46,46 +53,60 @@ func doUDPresponse(ip, res string) {
 		}
 		// log.Info.Printf("%+v", kd)
 		r := kd.System.Sysinfo
+		updateExtras(a, &r)
 
 		switch a.Device.(type) {
 		case *devices.KP115:
 			sw := a.Device.(*devices.KP115)
 			if sw.Outlet.On.GetValue() != (r.RelayState > 0) {
-				log.Info.Printf("updating HomeKit: [%s]:[%s] relay %d\n", a.IP, r.Alias, r.RelayState)
+				log.Info.Printf("updating HomeKit: [%s]:[%s] relay %d\n", ipOf(a), r.Alias, r.RelayState)
 				sw.Outlet.On.SetValue(r.RelayState > 0)
 				sw.Outlet.OutletInUse.SetValue(r.RelayState > 0)
 			}
 		case *devices.HS103:
 			sw := a.Device.(*devices.HS103)
 			if sw.Outlet.On.GetValue() != (r.RelayState > 0) {
-				log.Info.Printf("updating HomeKit: [%s]:[%s] relay %d\n", a.IP, r.Alias, r.RelayState)
+				log.Info.Printf("updating HomeKit: [%s]:[%s] relay %d\n", ipOf(a), r.Alias, r.RelayState)
 				sw.Outlet.On.SetValue(r.RelayState > 0)
 				sw.Outlet.OutletInUse.SetValue(r.RelayState > 0)
 			}
 		case *devices.HS200: // and 210
 			sw := a.Device.(*devices.HS200)
 			if sw.Switch.On.GetValue() != (r.RelayState > 0) {
-				log.Info.Printf("updating HomeKit: [%s]:[%s] relay %d\n", a.IP, r.Alias, r.RelayState)
+				log.Info.Printf("updating HomeKit: [%s]:[%s] relay %d\n", ipOf(a), r.Alias, r.RelayState)
 				sw.Switch.On.SetValue(r.RelayState > 0)
 			}
 		case *devices.HS220:
 			hs := a.Device.(*devices.HS220)
 			if hs.Lightbulb.On.GetValue() != (r.RelayState > 0) {
-				log.Info.Printf("updating HomeKit: [%s]:[%s] relay %d", a.IP, r.Alias, r.RelayState)
+				log.Info.Printf("updating HomeKit: [%s]:[%s] relay %d", ipOf(a), r.Alias, r.RelayState)
 				hs.Lightbulb.On.SetValue(r.RelayState > 0)
 			}
-			if hs.Lightbulb.Brightness.GetValue() != r.Brightness {
-				log.Info.Printf("updating HomeKit: [%s]:[%s] brightness %d", a.IP, r.Alias, r.RelayState)
-				hs.Lightbulb.Brightness.SetValue(r.Brightness)
+			if r.Brightness != nil && hs.Lightbulb.Brightness.GetValue() != *r.Brightness {
+				log.Info.Printf("updating HomeKit: [%s]:[%s] brightness %d", ipOf(a), r.Alias, *r.Brightness)
+				hs.Lightbulb.Brightness.SetValue(*r.Brightness)
 			}
-		case *devices.KP303:
-			kp := a.Device.(*devices.KP303)
-			for i := 0; i < len(kp.Outlets); i++ {
-				if kp.Outlets[i].On.GetValue() != (r.Children[i].RelayState > 0) {
-					log.Info.Printf("updating HomeKit: [%s]:[%s] relay %d", a.IP, r.Children[i].Alias, r.Children[i].RelayState)
-					kp.Outlets[i].On.SetValue(r.Children[0].RelayState > 0)
-					kp.Outlets[i].OutletInUse.SetValue(r.Children[0].RelayState > 0)
+		case *devices.KasaStrip:
+			ks := a.Device.(*devices.KasaStrip)
+			for _, c := range r.Children {
+				outlet := ks.Child(c.ID)
+				if outlet == nil {
+					log.Info.Printf("unknown child [%s] on [%s]", c.ID, a.Name)
+					continue
 				}
+				outlet.OnTime = c.OnTime
+				if outlet.Name.GetValue() != c.Alias {
+					outlet.Name.SetValue(c.Alias)
+				}
+				if outlet.On.GetValue() != (c.RelayState > 0) {
+					log.Info.Printf("updating HomeKit: [%s]:[%s] relay %d", ipOf(a), c.Alias, c.RelayState)
+					outlet.On.SetValue(c.RelayState > 0)
+					outlet.OutletInUse.SetValue(c.RelayState > 0)
+				}
+			}
+		case *devices.ColoredLightbulb, *devices.TempLightbulb, *devices.DimmableLightbulb:
+			if r.LightState != nil {
+				updateBulb(a, r.LightState)
 			}
 		default:
 			log.Info.Printf("unhandled sysinfo: %s\n", res)
@@ -94,6 +115,17 @@ func doUDPresponse(ip, res string) {
 	}
 
 	if strings.Contains(res, `"count_down"`) {
+		// whatever the strip itself says, ask each child
+		if _, ok := a.Device.(*devices.KasaStrip); ok {
+			if strings.Contains(res, `"get_rules"`) {
+				go getStripCountdowns(a)
+			}
+			return
+		}
+		// bulbs do not do countdowns
+		if strings.Contains(res, "module not support") {
+			return
+		}
 		// log.Info.Printf("processing: %s\n", res)
 		kd := kasaDevice{}
 		if err := json.Unmarshal([]byte(res), &kd); err != nil {
@@ -136,8 +168,6 @@ func doUDPresponse(ip, res string) {
 				if remaining != 0 {
 					kp.Outlet.RemainingDuration.SetValue(remaining)
 				}
-			case *devices.KP303:
-				// ignore for now
 			case *devices.HS103:
 				kp := a.Device.(*devices.HS103)
 				ps := kp.Outlet.ProgramMode.GetValue()
@@ -190,8 +220,6 @@ func doUDPresponse(ip, res string) {
 				hs := a.Device.(*devices.KP115)
 				hs.Outlet.ProgramMode.SetValue(characteristic.ProgramModeProgramScheduled)
 				// hs.Outlet.RemainingDuration.SetValue(remaining)
-			case *devices.KP303:
-				// ignore
 			case *devices.HS103:
 				hs := a.Device.(*devices.HS103)
 				hs.Outlet.ProgramMode.SetValue(characteristic.ProgramModeProgramScheduled)
@@ -217,8 +245,6 @@ func doUDPresponse(ip, res string) {
 				hs.Outlet.ProgramMode.SetValue(characteristic.ProgramModeNoProgramScheduled)
 				hs.Outlet.RemainingDuration.SetValue(0)
 				hs.Outlet.SetDuration.SetValue(0)
-			case *devices.KP303:
-				// ignore
 			case *devices.HS103:
 				hs := a.Device.(*devices.HS103)
 				hs.Outlet.ProgramMode.SetValue(characteristic.ProgramModeNoProgramScheduled)
@@ -242,13 +268,28 @@ func doUDPresponse(ip, res string) {
 
 	}
 
+	if strings.Contains(res, lightingService) {
+		doLightingResponse(a, res)
+		return
+	}
+
+	if strings.Contains(res, `"cnCloud"`) || strings.Contains(res, `"smartlife.iot.common.cloud"`) {
+		doCloudResponse(a, res)
+		return
+	}
+
+	if strings.Contains(res, `"emeter"`) {
+		doEmeterResponse(a, res)
+		return
+	}
+
 	if res == `{"system":{"set_relay_state":{"err_code":0}}}` {
 		// log.Info.Printf("[%s] relay state changed", a.Name)
 		return
 	}
 
-	if res == `{"smartlife.iot.dimmer":{"set_brightness":{"err_code":0}}}` {
-		// log.Info.Printf("[%s] brightness changed", a.Name)
+	if strings.HasPrefix(res, `{"smartlife.iot.dimmer":`) && checkErrCode(res) == nil {
+		// log.Info.Printf("[%s] dimmer setting changed", a.Name)
 		return
 	}
 
@@ -0,0 +1,472 @@
+package kasasim
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// the errors real devices return
+var (
+	errModule = map[string]interface{}{"err_code": -1, "err_msg": "module not support"}
+	errMember = map[string]interface{}{"err_code": -2, "err_msg": "member not support"}
+	errParam  = map[string]interface{}{"err_code": -3, "err_msg": "invalid argument"}
+	errFull   = map[string]interface{}{"err_code": -10, "err_msg": "table is full"}
+	errChild  = map[string]interface{}{"err_code": -14, "err_msg": "entry not exist"}
+	errNone   = map[string]interface{}{"err_code": 0}
+)
+
+type request struct {
+	Context struct {
+		ChildIDs []string `json:"child_ids"`
+	} `json:"context"`
+}
+
+// Handle answers a single decrypted request the way the device would, it is what the listeners call
+func (d *Device) Handle(req string) string {
+	var modules map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(req), &modules); err != nil {
+		return `{"err_code":-1,"err_msg":"json decode error"}`
+	}
+
+	var r request
+	json.Unmarshal([]byte(req), &r)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.accumulate(time.Now())
+
+	// commands sent with a context apply to the listed outlets
+	var children []*Child
+	for _, id := range r.Context.ChildIDs {
+		c := d.child(id)
+		if c == nil {
+			return marshal(map[string]interface{}{"context": errChild})
+		}
+		children = append(children, c)
+	}
+
+	out := make(map[string]interface{})
+	for module, raw := range modules {
+		if module == "context" {
+			continue
+		}
+		var methods map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &methods); err != nil {
+			out[module] = errParam
+			continue
+		}
+
+		var handler func(string, json.RawMessage, []*Child) interface{}
+		switch module {
+		case "system":
+			handler = d.systemModule
+		case "smartlife.iot.dimmer":
+			if d.model.Dimmer {
+				handler = d.dimmerModule
+			}
+		case "count_down":
+			handler = d.countdownModule
+		case "emeter":
+			if strings.Contains(d.model.Feature, "ENE") {
+				handler = d.emeterModule
+			}
+		}
+		if handler == nil {
+			out[module] = errModule
+			continue
+		}
+
+		res := make(map[string]interface{})
+		for method, args := range methods {
+			res[method] = handler(method, args, children)
+		}
+		out[module] = res
+	}
+	return marshal(out)
+}
+
+func marshal(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func (d *Device) child(id string) *Child {
+	for _, c := range d.children {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// accumulate adds the energy used since the last request to the totals
+func (d *Device) accumulate(now time.Time) {
+	hours := now.Sub(d.lastUpdate).Hours()
+	d.lastUpdate = now
+	if d.relayState == 1 {
+		d.totalWH += d.Watts * hours
+	}
+	for _, c := range d.children {
+		if c.relayState == 1 {
+			c.totalWH += d.Watts * hours
+		}
+	}
+}
+
+// setRelay must be called with the lock held, c is nil for the device itself
+func (d *Device) setRelay(c *Child, state int) {
+	if c == nil {
+		if d.relayState != state {
+			d.onSince = time.Now()
+		}
+		d.relayState = state
+		return
+	}
+	if c.relayState != state {
+		c.onSince = time.Now()
+	}
+	c.relayState = state
+}
+
+func onTime(state int, since time.Time) int {
+	if state == 0 {
+		return 0
+	}
+	return int(time.Since(since).Seconds())
+}
+
+func (d *Device) systemModule(method string, args json.RawMessage, children []*Child) interface{} {
+	switch method {
+	case "get_sysinfo":
+		return d.sysinfo()
+	case "set_relay_state":
+		var a struct {
+			State *int `json:"state"`
+		}
+		if err := json.Unmarshal(args, &a); err != nil || a.State == nil || *a.State < 0 || *a.State > 1 {
+			return errParam
+		}
+		if len(d.children) > 0 && len(children) == 0 {
+			// strips switch every outlet
+			children = d.children
+		}
+		if len(children) == 0 {
+			d.setRelay(nil, *a.State)
+		}
+		for _, c := range children {
+			d.setRelay(c, *a.State)
+		}
+		return errNone
+	case "set_alias":
+		var a struct {
+			Alias string `json:"alias"`
+		}
+		if err := json.Unmarshal(args, &a); err != nil {
+			return errParam
+		}
+		if len(children) == 0 {
+			d.Alias = a.Alias
+		}
+		for _, c := range children {
+			c.Alias = a.Alias
+		}
+		return errNone
+	case "set_led_off":
+		var a struct {
+			Off int `json:"off"`
+		}
+		if err := json.Unmarshal(args, &a); err != nil {
+			return errParam
+		}
+		d.ledOff = a.Off
+		return errNone
+	case "reboot":
+		return errNone
+	}
+	return errMember
+}
+
+func (d *Device) sysinfo() interface{} {
+	s := map[string]interface{}{
+		"sw_ver":      "1.0.0 Build 000000 Rel.000000",
+		"hw_ver":      "1.0",
+		"model":       d.Model + "(US)",
+		"deviceId":    d.DeviceID,
+		"oemId":       "00000000000000000000000000000000",
+		"hwId":        "00000000000000000000000000000000",
+		"rssi":        -50,
+		"alias":       d.Alias,
+		"status":      "new",
+		"mic_type":    "IOT.SMARTPLUGSWITCH",
+		"feature":     d.model.Feature,
+		"mac":         d.MAC,
+		"updating":    0,
+		"led_off":     d.ledOff,
+		"active_mode": "none",
+		"dev_name":    d.model.DevName,
+		"err_code":    0,
+	}
+
+	if len(d.children) == 0 {
+		s["relay_state"] = d.relayState
+		s["on_time"] = onTime(d.relayState, d.onSince)
+	} else {
+		var children []map[string]interface{}
+		for _, c := range d.children {
+			children = append(children, map[string]interface{}{
+				"id":          c.ID,
+				"state":       c.relayState,
+				"alias":       c.Alias,
+				"on_time":     onTime(c.relayState, c.onSince),
+				"next_action": map[string]interface{}{"type": -1},
+			})
+		}
+		s["children"] = children
+		s["child_num"] = len(children)
+	}
+	if d.model.Dimmer {
+		s["brightness"] = d.brightness
+	}
+	return s
+}
+
+func (d *Device) dimmerModule(method string, args json.RawMessage, children []*Child) interface{} {
+	switch method {
+	case "set_brightness":
+		var a struct {
+			Brightness *int `json:"brightness"`
+		}
+		if err := json.Unmarshal(args, &a); err != nil || a.Brightness == nil || *a.Brightness < 0 || *a.Brightness > 100 {
+			return errParam
+		}
+		d.brightness = *a.Brightness
+		return errNone
+	case "set_dimmer_transition":
+		var a struct {
+			Brightness *int `json:"brightness"`
+			Duration   int  `json:"duration"`
+		}
+		if err := json.Unmarshal(args, &a); err != nil || a.Brightness == nil || *a.Brightness < 0 || *a.Brightness > 100 {
+			return errParam
+		}
+		// the fade itself is not simulated, only where it ends up
+		if *a.Brightness == 0 {
+			d.setRelay(nil, 0)
+			return errNone
+		}
+		d.brightness = *a.Brightness
+		d.setRelay(nil, 1)
+		return errNone
+	case "set_fade_on_time", "set_fade_off_time":
+		var a struct {
+			FadeTime *int `json:"fadeTime"`
+		}
+		if err := json.Unmarshal(args, &a); err != nil || a.FadeTime == nil || *a.FadeTime < 0 {
+			return errParam
+		}
+		d.dimmerParams[strings.TrimPrefix(method, "set_")] = *a.FadeTime
+		return errNone
+	case "set_gentle_on_time", "set_gentle_off_time":
+		var a struct {
+			Duration *int `json:"duration"`
+		}
+		if err := json.Unmarshal(args, &a); err != nil || a.Duration == nil || *a.Duration < 0 {
+			return errParam
+		}
+		d.dimmerParams[strings.TrimPrefix(method, "set_")] = *a.Duration
+		return errNone
+	case "get_dimmer_parameters":
+		return map[string]interface{}{
+			"minThreshold":  0,
+			"fadeOnTime":    d.dimmerParams["fade_on_time"],
+			"fadeOffTime":   d.dimmerParams["fade_off_time"],
+			"gentleOnTime":  d.dimmerParams["gentle_on_time"],
+			"gentleOffTime": d.dimmerParams["gentle_off_time"],
+			"rampRate":      30,
+			"bulb_type":     1,
+			"err_code":      0,
+		}
+	case "set_double_click_action":
+		var a struct {
+			Mode string `json:"mode"`
+		}
+		if err := json.Unmarshal(args, &a); err != nil {
+			return errParam
+		}
+		switch a.Mode {
+		case "none", "instant_on_off", "gentle_on_off":
+			d.doubleClick = a.Mode
+			return errNone
+		}
+		return errParam
+	case "get_double_click_action":
+		return map[string]interface{}{"mode": d.doubleClick, "err_code": 0}
+	}
+	return errMember
+}
+
+// countdown rules are held per outlet on strips, requests without a context go to the device itself
+func (d *Device) countdownModule(method string, args json.RawMessage, children []*Child) interface{} {
+	targets := []*Child{nil}
+	if len(children) > 0 {
+		targets = children
+	}
+
+	switch method {
+	case "get_rules":
+		var list []map[string]interface{}
+		for _, c := range targets {
+			if cd := d.getCountdown(c); cd != nil {
+				remain := 0
+				if cd.enable == 1 {
+					remain = int(math.Ceil(time.Until(cd.end).Seconds()))
+				}
+				list = append(list, map[string]interface{}{
+					"id":     cd.id,
+					"name":   cd.name,
+					"enable": cd.enable,
+					"delay":  cd.delay,
+					"act":    cd.act,
+					"remain": remain,
+				})
+			}
+		}
+		if list == nil {
+			list = []map[string]interface{}{}
+		}
+		return map[string]interface{}{"rule_list": list, "err_code": 0}
+	case "add_rule":
+		var a struct {
+			Enable int    `json:"enable"`
+			Delay  int    `json:"delay"`
+			Act    int    `json:"act"`
+			Name   string `json:"name"`
+		}
+		if err := json.Unmarshal(args, &a); err != nil || a.Delay <= 0 {
+			return errParam
+		}
+		var id string
+		for _, c := range targets {
+			// each outlet holds one rule, even once it has run
+			if d.getCountdown(c) != nil {
+				return errFull
+			}
+			id = d.addCountdown(c, a.Name, a.Enable, a.Delay, a.Act)
+		}
+		return map[string]interface{}{"id": id, "err_code": 0}
+	case "delete_all_rules":
+		for _, c := range targets {
+			d.clearCountdown(c)
+		}
+		return errNone
+	}
+	return errMember
+}
+
+func (d *Device) getCountdown(c *Child) *countdown {
+	if c == nil {
+		return d.countdown
+	}
+	return c.countdown
+}
+
+// addCountdown must be called with the lock held
+func (d *Device) addCountdown(c *Child, name string, enable, delay, act int) string {
+	cd := &countdown{
+		id:     fmt.Sprintf("%032X", time.Now().UnixNano()),
+		name:   name,
+		enable: enable,
+		delay:  delay,
+		act:    act,
+		end:    time.Now().Add(time.Duration(delay) * time.Second),
+	}
+	if enable == 1 {
+		cd.timer = time.AfterFunc(time.Duration(delay)*time.Second, func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			d.accumulate(time.Now())
+			d.setRelay(c, act)
+			cd.enable = 0
+		})
+	}
+
+	if c == nil {
+		d.countdown = cd
+	} else {
+		c.countdown = cd
+	}
+	return cd.id
+}
+
+// clearCountdown must be called with the lock held
+func (d *Device) clearCountdown(c *Child) {
+	cd := d.getCountdown(c)
+	if cd == nil {
+		return
+	}
+	if cd.timer != nil {
+		cd.timer.Stop()
+	}
+	if c == nil {
+		d.countdown = nil
+	} else {
+		c.countdown = nil
+	}
+}
+
+// emeter readings are in the milli-units newer hardware uses; without a context strips report the total
+func (d *Device) emeterModule(method string, args json.RawMessage, children []*Child) interface{} {
+	var on int
+	var wh float64
+	switch {
+	case len(children) > 0:
+		for _, c := range children {
+			on += c.relayState
+			wh += c.totalWH
+		}
+	case len(d.children) > 0:
+		for _, c := range d.children {
+			on += c.relayState
+			wh += c.totalWH
+		}
+	default:
+		on = d.relayState
+		wh = d.totalWH
+	}
+	watts := d.Watts * float64(on)
+	now := time.Now()
+
+	switch method {
+	case "get_realtime":
+		amps := 0.0
+		if d.Volts != 0 {
+			amps = watts / d.Volts
+		}
+		return map[string]interface{}{
+			"voltage_mv": int(d.Volts * 1000),
+			"current_ma": int(amps * 1000),
+			"power_mw":   int(watts * 1000),
+			"total_wh":   int(wh),
+			"err_code":   0,
+		}
+	case "get_daystat":
+		// everything the simulator has used happened today
+		return map[string]interface{}{
+			"day_list": []map[string]interface{}{
+				{"year": now.Year(), "month": int(now.Month()), "day": now.Day(), "energy_wh": int(wh)},
+			},
+			"err_code": 0,
+		}
+	case "get_monthstat":
+		return map[string]interface{}{
+			"month_list": []map[string]interface{}{
+				{"year": now.Year(), "month": int(now.Month()), "energy_wh": int(wh)},
+			},
+			"err_code": 0,
+		}
+	}
+	return errMember
+}
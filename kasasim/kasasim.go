@@ -0,0 +1,187 @@
+// Package kasasim simulates Kasa smart plugs, switches, dimmers and strips on the XOR (port 9999) and KLAP protocols.
+// It is meant for exercising the kasa platform without real hardware, from tests or the kasa-sim command.
+package kasasim
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Model describes what a simulated device reports and can do
+type Model struct {
+	DevName  string
+	Feature  string // "TIM", or "TIM:ENE" for energy monitoring
+	Children int    // number of outlets on a strip, 0 for single-outlet devices
+	Dimmer   bool
+}
+
+// Models are the devices which can be simulated, by model number
+var Models = map[string]Model{
+	"HS103": {DevName: "Smart Wi-Fi Plug Lite", Feature: "TIM"},
+	"HS105": {DevName: "Smart Wi-Fi Plug Mini", Feature: "TIM"},
+	"HS200": {DevName: "Smart Wi-Fi Light Switch", Feature: "TIM"},
+	"HS210": {DevName: "Smart Wi-Fi 3-Way Light Switch", Feature: "TIM"},
+	"HS220": {DevName: "Smart Wi-Fi Dimmer", Feature: "TIM", Dimmer: true},
+	"KP115": {DevName: "Smart Wi-Fi Plug Mini", Feature: "TIM:ENE"},
+	"KP303": {DevName: "Wi-Fi Smart Power Strip", Feature: "TIM", Children: 3},
+	"KP400": {DevName: "Smart Wi-Fi Outdoor Plug", Feature: "TIM", Children: 2},
+	"HS300": {DevName: "Wi-Fi Smart Power Strip", Feature: "TIM:ENE", Children: 6},
+}
+
+// ModelNames lists the models which can be simulated
+func ModelNames() []string {
+	var names []string
+	for n := range Models {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Device is a single simulated device, safe for concurrent use
+type Device struct {
+	mu sync.Mutex
+
+	Model    string
+	Alias    string
+	DeviceID string
+	MAC      string
+	Watts    float64 // reported by energy monitoring devices (per outlet on strips) while on
+	Volts    float64
+
+	model      Model
+	relayState int
+	brightness int
+	ledOff     int
+
+	// dimmers
+	dimmerParams map[string]int // fade_on_time, fade_off_time, gentle_on_time, gentle_off_time in ms
+	doubleClick  string
+	onSince      time.Time
+	countdown    *countdown
+	totalWH      float64
+	lastUpdate   time.Time
+	children     []*Child
+
+	udp  *net.UDPConn
+	tcp  net.Listener
+	klap *klapServer
+	wg   sync.WaitGroup
+}
+
+// Child is one outlet of a simulated strip
+type Child struct {
+	ID         string
+	Alias      string
+	relayState int
+	onSince    time.Time
+	countdown  *countdown
+	totalWH    float64
+}
+
+// countdown is the single count_down rule a device (or child) holds
+type countdown struct {
+	id     string
+	name   string
+	enable int // cleared once the countdown has run
+	delay  int
+	act    int
+	end    time.Time
+	timer  *time.Timer
+}
+
+// New creates a device of a model from Models, it does nothing until Listen is called
+func New(model, alias string) (*Device, error) {
+	m, ok := Models[model]
+	if !ok {
+		return nil, fmt.Errorf("unknown model %s", model)
+	}
+
+	// stable IDs derived from the alias so restarting the simulator does not look like new devices
+	var h uint32 = 2166136261
+	for i := 0; i < len(alias); i++ {
+		h = (h ^ uint32(alias[i])) * 16777619
+	}
+
+	d := Device{
+		Model:      model,
+		Alias:      alias,
+		DeviceID:   fmt.Sprintf("80060000000000000000000000000000%08X", h),
+		MAC:        fmt.Sprintf("50:C7:BF:%02X:%02X:%02X", byte(h>>16), byte(h>>8), byte(h)),
+		Watts:      60,
+		Volts:      120,
+		model:      m,
+		brightness: 100,
+		lastUpdate: time.Now(),
+
+		dimmerParams: map[string]int{"fade_on_time": 1000, "fade_off_time": 1000, "gentle_on_time": 3000, "gentle_off_time": 10000},
+		doubleClick:  "none",
+	}
+	for i := 0; i < m.Children; i++ {
+		d.children = append(d.children, &Child{
+			ID:    fmt.Sprintf("%s%02d", d.DeviceID, i),
+			Alias: fmt.Sprintf("%s Outlet %d", alias, i+1),
+		})
+	}
+	return &d, nil
+}
+
+// Children returns the outlets of a strip, nil for other devices
+func (d *Device) Children() []*Child {
+	return d.children
+}
+
+// On reports the relay state, for strips it is true if any outlet is on
+func (d *Device) On() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.children) == 0 {
+		return d.relayState == 1
+	}
+	for _, c := range d.children {
+		if c.relayState == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// ChildOn reports the relay state of one outlet of a strip
+func (d *Device) ChildOn(i int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.children[i].relayState == 1
+}
+
+// Brightness reports the dimmer level
+func (d *Device) Brightness() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.brightness
+}
+
+// SetOn changes the relay state as if the button on the device was pressed
+func (d *Device) SetOn(on bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.accumulate(time.Now())
+	d.setRelay(nil, boolToInt(on))
+}
+
+// SetChildOn changes the relay state of one outlet as if its button was pressed
+func (d *Device) SetChildOn(i int, on bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.accumulate(time.Now())
+	d.setRelay(d.children[i], boolToInt(on))
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
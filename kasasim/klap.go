@@ -0,0 +1,184 @@
+package kasasim
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// newer firmware drops the XOR protocol for KLAP, an HTTP handshake followed by AES-encrypted requests
+// the simulator answers the same commands either way
+
+// klapServer is the device side of KLAP for one simulated device, it holds a single session
+type klapServer struct {
+	mu       sync.Mutex
+	version  int
+	authHash []byte
+	srv      *http.Server
+	addr     string
+
+	local  []byte
+	remote []byte
+	authed bool
+	cookie string
+}
+
+func sha256sum(b ...[]byte) []byte {
+	h := sha256.Sum256(bytes.Join(b, nil))
+	return h[:]
+}
+
+// ListenKLAP starts answering KLAP on addr (host:port) with the credentials the device was set up with, version is 1 or 2
+// the XOR listeners are not needed, KLAP devices do not answer them
+func (d *Device) ListenKLAP(addr string, version int, username, password string) error {
+	k := klapServer{version: version}
+	switch version {
+	case 1:
+		u, p := md5.Sum([]byte(username)), md5.Sum([]byte(password))
+		h := md5.Sum(append(u[:], p[:]...))
+		k.authHash = h[:]
+	case 2:
+		u, p := sha1.Sum([]byte(username)), sha1.Sum([]byte(password))
+		k.authHash = sha256sum(u[:], p[:])
+	default:
+		return fmt.Errorf("unknown klap version %d", version)
+	}
+
+	l, err := net.Listen("tcp4", addr)
+	if err != nil {
+		return err
+	}
+	k.addr = l.Addr().String()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/app/handshake1", k.handshake1)
+	mux.HandleFunc("/app/handshake2", k.handshake2)
+	mux.HandleFunc("/app/request", func(w http.ResponseWriter, r *http.Request) {
+		k.request(w, r, d.Handle)
+	})
+	k.srv = &http.Server{Handler: mux}
+	d.klap = &k
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		k.srv.Serve(l)
+	}()
+	return nil
+}
+
+// KLAPAddr is the host:port the device answers KLAP on
+func (d *Device) KLAPAddr() string {
+	if d.klap == nil {
+		return ""
+	}
+	return d.klap.addr
+}
+
+func (k *klapServer) handshake1(w http.ResponseWriter, r *http.Request) {
+	local, _ := ioutil.ReadAll(r.Body)
+	if len(local) != 16 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.local = local
+	k.remote = make([]byte, 16)
+	rand.Read(k.remote)
+	id := make([]byte, 16)
+	rand.Read(id)
+	k.cookie = fmt.Sprintf("TP_SESSIONID=%X", id)
+	k.authed = false
+
+	var serverHash []byte
+	if k.version == 1 {
+		serverHash = sha256sum(k.local, k.authHash)
+	} else {
+		serverHash = sha256sum(k.local, k.remote, k.authHash)
+	}
+	w.Header().Set("Set-Cookie", k.cookie+";TIMEOUT=86400")
+	w.Write(append(append([]byte{}, k.remote...), serverHash...))
+}
+
+func (k *klapServer) handshake2(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var want []byte
+	if k.version == 1 {
+		want = sha256sum(k.remote, k.authHash)
+	} else {
+		want = sha256sum(k.remote, k.local, k.authHash)
+	}
+	if r.Header.Get("Cookie") != k.cookie || !bytes.Equal(body, want) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	k.authed = true
+}
+
+func (k *klapServer) request(w http.ResponseWriter, r *http.Request, handle func(string) string) {
+	body, _ := ioutil.ReadAll(r.Body)
+	seq, err := strconv.Atoi(r.URL.Query().Get("seq"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	k.mu.Lock()
+	if !k.authed || r.Header.Get("Cookie") != k.cookie {
+		k.mu.Unlock()
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	key := sha256sum([]byte("lsk"), k.local, k.remote, k.authHash)[:16]
+	ivseq := sha256sum([]byte("iv"), k.local, k.remote, k.authHash)
+	sig := sha256sum([]byte("ldk"), k.local, k.remote, k.authHash)[:28]
+	k.mu.Unlock()
+
+	iv := make([]byte, 16)
+	copy(iv, ivseq[:12])
+	binary.BigEndian.PutUint32(iv[12:], uint32(seq))
+	s := make([]byte, 4)
+	binary.BigEndian.PutUint32(s, uint32(seq))
+
+	if len(body) < 32+aes.BlockSize || (len(body)-32)%aes.BlockSize != 0 || !bytes.Equal(body[:32], sha256sum(sig, s, body[32:])) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	plain := make([]byte, len(body)-32)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, body[32:])
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	res := []byte(handle(string(plain[:len(plain)-pad])))
+	pad = aes.BlockSize - len(res)%aes.BlockSize
+	res = append(res, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	out := make([]byte, len(res))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, res)
+	w.Write(append(sha256sum(sig, s, out), out...))
+}
@@ -0,0 +1,153 @@
+package kasasim
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// largest request the simulator will read over TCP
+const maxRequest = 64 * 1024
+
+// Listen starts answering on UDP and TCP at addr (host:port); if the port is 0 one is chosen and TCP uses the same port as UDP
+func (d *Device) Listen(addr string) error {
+	ua, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return err
+	}
+	udp, err := net.ListenUDP("udp4", ua)
+	if err != nil {
+		return err
+	}
+	tcp, err := net.Listen("tcp4", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		return err
+	}
+	d.udp = udp
+	d.tcp = tcp
+
+	d.wg.Add(2)
+	go d.serveUDP()
+	go d.serveTCP()
+	return nil
+}
+
+// Addr is the host:port the device is listening on
+func (d *Device) Addr() string {
+	if d.udp == nil {
+		return ""
+	}
+	return d.udp.LocalAddr().String()
+}
+
+// Close stops the listeners and any pending countdowns
+func (d *Device) Close() error {
+	if d.udp == nil && d.klap == nil {
+		return nil
+	}
+	if d.udp != nil {
+		d.udp.Close()
+		d.tcp.Close()
+	}
+	if d.klap != nil {
+		d.klap.srv.Close()
+	}
+	d.wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clearCountdown(nil)
+	for _, c := range d.children {
+		d.clearCountdown(c)
+	}
+	return nil
+}
+
+func (d *Device) serveUDP() {
+	defer d.wg.Done()
+
+	buf := make([]byte, maxRequest)
+	for {
+		n, addr, err := d.udp.ReadFromUDP(buf)
+		if err != nil {
+			// closed
+			return
+		}
+		res := d.Handle(decrypt(buf[:n]))
+		if _, err := d.udp.WriteToUDP(encrypt(res), addr); err != nil {
+			continue
+		}
+	}
+}
+
+func (d *Device) serveTCP() {
+	defer d.wg.Done()
+
+	for {
+		conn, err := d.tcp.Accept()
+		if err != nil {
+			// closed
+			return
+		}
+		go d.serveConn(conn)
+	}
+}
+
+// serveConn answers requests until the client hangs up, each is prefixed with its length
+func (d *Device) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(time.Minute))
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(header)
+		if n > maxRequest {
+			return
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return
+		}
+
+		res := encrypt(d.Handle(decrypt(payload)))
+		out := make([]byte, 4, 4+len(res))
+		binary.BigEndian.PutUint32(out, uint32(len(res)))
+		if _, err := conn.Write(append(out, res...)); err != nil {
+			return
+		}
+	}
+}
+
+// the autokey XOR cipher, the same both ways with the key chained on the ciphertext
+func encrypt(plaintext string) []byte {
+	key := byte(0xAB)
+	out := make([]byte, len(plaintext))
+	for i := 0; i < len(plaintext); i++ {
+		out[i] = plaintext[i] ^ key
+		key = out[i]
+	}
+	return out
+}
+
+func decrypt(ciphertext []byte) string {
+	key := byte(0xAB)
+	out := make([]byte, len(ciphertext))
+	for i, c := range ciphertext {
+		out[i] = c ^ key
+		key = c
+	}
+	return string(out)
+}
+
+func (d *Device) String() string {
+	if d.klap != nil {
+		return fmt.Sprintf("%s [%s] on %s (klap)", d.Model, d.Alias, d.KLAPAddr())
+	}
+	return fmt.Sprintf("%s [%s] on %s", d.Model, d.Alias, d.Addr())
+}
@@ -0,0 +1,121 @@
+package konnected
+
+import (
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/devices"
+
+	"bytes"
+	"encoding/json"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/log"
+)
+
+// how long to hold a garage door opener's button if the zone does not say
+const defaultGaragePulse = 500
+
+// addActuator registers the service for an actuator zone and installs its HomeKit handlers
+func addActuator(a *tfaccessory.TFAccessory, z tfaccessory.Zone) {
+	k := a.Device.(*devices.Konnected)
+
+	switch z.Type {
+	case "switch", "siren", "strobe":
+		p := devices.NewKonnectedSwitch(z.Name)
+		k.Pins[z.Pin] = p
+		a.Accessory.AddService(p.Service)
+		p.On.OnValueRemoteUpdate(func(newstate bool) {
+			log.Info.Printf("setting [%s] to [%t]", z.Name, newstate)
+			if err := actuate(a, z, newstate); err != nil {
+				log.Info.Println(err.Error())
+				p.On.SetValue(!newstate)
+			}
+		})
+	case "garage":
+		p := devices.NewKonnectedGarageDoor(z.Name)
+		p.SensorPin = z.Sensor
+		k.Pins[z.Pin] = p
+		k.Pins[z.Sensor] = p
+		a.Accessory.AddService(p.Service)
+		p.TargetDoorState.OnValueRemoteUpdate(func(newval int) {
+			current := p.CurrentDoorState.GetValue()
+			if (newval == characteristic.TargetDoorStateOpen && current == characteristic.CurrentDoorStateOpen) ||
+				(newval == characteristic.TargetDoorStateClosed && current == characteristic.CurrentDoorStateClosed) {
+				return
+			}
+			log.Info.Printf("operating [%s]", z.Name)
+			// the opener only has a button, always pulse it
+			if z.Momentary == 0 {
+				z.Momentary = defaultGaragePulse
+			}
+			if err := actuate(a, z, true); err != nil {
+				log.Info.Println(err.Error())
+				return
+			}
+			if newval == characteristic.TargetDoorStateOpen {
+				p.CurrentDoorState.SetValue(characteristic.CurrentDoorStateOpening)
+			} else {
+				p.CurrentDoorState.SetValue(characteristic.CurrentDoorStateClosing)
+			}
+		})
+	case "valve":
+		p := devices.NewKonnectedValve(z.Name)
+		k.Pins[z.Pin] = p
+		a.Accessory.AddService(p.Service)
+		p.Active.OnValueRemoteUpdate(func(newval int) {
+			log.Info.Printf("setting [%s] to [%d]", z.Name, newval)
+			if err := actuate(a, z, newval == characteristic.ActiveActive); err != nil {
+				log.Info.Println(err.Error())
+				p.Active.SetValue(1 - newval)
+				return
+			}
+			p.InUse.SetValue(newval)
+		})
+	}
+}
+
+// actuate drives the pin, turning on uses the zone's momentary/times/pause settings
+func actuate(a *tfaccessory.TFAccessory, z tfaccessory.Zone, on bool) error {
+	cmd := command{Pin: z.Pin}
+	if on {
+		cmd.State = 1
+		cmd.Momentary = z.Momentary
+		cmd.Times = z.Times
+		cmd.Pause = z.Pause
+	}
+	toWire(isPro(a), &cmd.Pin, &cmd.Zone)
+	buf, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+
+	_, err = doRequest(a, "PUT", deviceURL(a), bytes.NewBuffer(buf))
+	return err
+}
+
+// updateActuator brings HomeKit in line with a state the board reported
+func updateActuator(svc interface{}, pin uint8, state uint8) {
+	switch svc.(type) {
+	case *devices.KonnectedSwitch:
+		sw := svc.(*devices.KonnectedSwitch)
+		if sw.On.GetValue() != (state == 1) {
+			sw.On.SetValue(state == 1)
+		}
+	case *devices.KonnectedGarageDoor:
+		g := svc.(*devices.KonnectedGarageDoor)
+		if pin != g.SensorPin {
+			// the opener pin pulsing
+			return
+		}
+		// the sensor is open (1) when the door is not closed
+		if state == 0 {
+			g.CurrentDoorState.SetValue(characteristic.CurrentDoorStateClosed)
+			g.TargetDoorState.SetValue(characteristic.TargetDoorStateClosed)
+		} else {
+			g.CurrentDoorState.SetValue(characteristic.CurrentDoorStateOpen)
+			g.TargetDoorState.SetValue(characteristic.TargetDoorStateOpen)
+		}
+	case *devices.KonnectedValve:
+		v := svc.(*devices.KonnectedValve)
+		v.Active.SetValue(int(state))
+		v.InUse.SetValue(int(state))
+	}
+}
@@ -0,0 +1,443 @@
+package konnected
+
+import (
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+
+	"github.com/brutella/hc/characteristic"
+	"sync"
+	"time"
+)
+
+// used when the accessory config does not set KonnectedAlarm
+var defaultAlarm = tfaccessory.AlarmSettings{
+	Stay:  tfaccessory.AlarmDelays{Entry: 30},
+	Away:  tfaccessory.AlarmDelays{Entry: 60, Exit: 60},
+	Night: tfaccessory.AlarmDelays{},
+	Siren: 300,
+}
+
+// where the security system is, HomeKit only sees the current and target states
+type phase uint8
+
+const (
+	phaseDisarmed phase = iota
+	phaseExit           // arming, waiting for the exit delay
+	phaseArmed
+	phaseEntry // a delayed zone opened, waiting for the entry delay
+	phaseTriggered
+)
+
+// alarmOutputs are what the security system drives, kept apart so the state machine can run without a board
+type alarmOutputs interface {
+	state(current int)          // the HomeKit current state
+	beep()                      // acknowledge arming and disarming
+	chirp()                     // a door opened
+	warn(delay time.Duration)   // the entry or exit delay is running
+	siren(on bool, zone string) // sound the alarm, zone is what tripped it
+	save(st alarmState)         // the state changed
+}
+
+// pending records the outputs while the lock is held, they are performed once it is released
+// so a slow board or a full noonlight queue does not hold up the handler, arming, or disarming
+type pending struct {
+	out alarmOutputs
+	q   []func()
+}
+
+func (p *pending) state(current int)          { p.q = append(p.q, func() { p.out.state(current) }) }
+func (p *pending) beep()                      { p.q = append(p.q, p.out.beep) }
+func (p *pending) chirp()                     { p.q = append(p.q, p.out.chirp) }
+func (p *pending) warn(delay time.Duration)   { p.q = append(p.q, func() { p.out.warn(delay) }) }
+func (p *pending) siren(on bool, zone string) { p.q = append(p.q, func() { p.out.siren(on, zone) }) }
+func (p *pending) save(st alarmState)         { p.q = append(p.q, func() { p.out.save(st) }) }
+
+// alarmState is what is saved to resume after a restart
+type alarmState struct {
+	Phase    phase     `json:"phase"`
+	Mode     int       `json:"mode"`
+	Zone     string    `json:"zone,omitempty"`
+	Sounding bool      `json:"sounding,omitempty"`
+	Deadline time.Time `json:"deadline"` // when the running delay or the siren ends, zero if nothing is running
+}
+
+// stopper is a running timer, *time.Timer satisfies it
+type stopper interface {
+	Stop() bool
+}
+
+// securitySystem is the alarm logic of one board
+type securitySystem struct {
+	mu       sync.Mutex
+	settings tfaccessory.AlarmSettings
+	out      *pending
+	flushing bool                                // a caller is performing the outputs
+	after    func(time.Duration, func()) stopper // time.AfterFunc, replaceable to run without waiting
+	now      func() time.Time
+
+	phase    phase
+	mode     int    // the HomeKit state armed to, or disarmed
+	zone     string // the zone which tripped the entry delay or the alarm
+	sounding bool
+	timer    stopper
+	deadline time.Time
+	gen      uint64 // bumped every time the timer changes, so a timer which fired while being stopped does nothing
+}
+
+func newSecuritySystem(settings *tfaccessory.AlarmSettings, out alarmOutputs) *securitySystem {
+	s := securitySystem{
+		settings: defaultAlarm,
+		out:      &pending{out: out},
+		after: func(d time.Duration, f func()) stopper {
+			return time.AfterFunc(d, f)
+		},
+		now:  time.Now,
+		mode: characteristic.SecuritySystemCurrentStateDisarmed,
+	}
+	if settings != nil {
+		s.settings = *settings
+	}
+	return &s
+}
+
+// arm moves to the HomeKit target state, returns false if it cannot be done now
+func (s *securitySystem) arm(target int) bool {
+	if target == characteristic.SecuritySystemTargetStateDisarm {
+		s.disarm()
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.unlock()
+
+	if s.phase == phaseEntry || s.phase == phaseTriggered {
+		// disarm first, changing modes must not cancel the entry delay
+		return false
+	}
+	delays, ok := s.delays(target)
+	if !ok {
+		return false
+	}
+
+	s.stop()
+	s.zone = ""
+	s.mode = target
+	if delays.Exit == 0 {
+		s.armed()
+		return true
+	}
+
+	s.phase = phaseExit
+	delay := time.Duration(delays.Exit) * time.Second
+	s.out.warn(delay)
+	s.schedule(delay, s.armed)
+	s.changed()
+	return true
+}
+
+func (s *securitySystem) armed() {
+	s.phase = phaseArmed
+	s.out.state(s.mode)
+	s.out.beep()
+	s.changed()
+}
+
+func (s *securitySystem) disarm() {
+	s.mu.Lock()
+	defer s.unlock()
+
+	s.stop()
+	if s.phase != phaseDisarmed && s.phase != phaseArmed {
+		// quiet the siren or the delay warning
+		s.out.siren(false, s.zone)
+	}
+	s.phase = phaseDisarmed
+	s.mode = characteristic.SecuritySystemCurrentStateDisarmed
+	s.zone = ""
+	s.sounding = false
+	s.out.state(s.mode)
+	s.out.beep()
+	s.changed()
+}
+
+// opened is called when a motion or door zone trips
+func (s *securitySystem) opened(z tfaccessory.Zone) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	behaviour := zoneBehaviour(z)
+	if s.phase == phaseTriggered {
+		return
+	}
+	if behaviour == "24h" {
+		s.trigger(z.Name)
+		return
+	}
+
+	switch s.phase {
+	case phaseDisarmed:
+		if z.Type == "door" {
+			s.out.chirp()
+		}
+		return
+	case phaseExit:
+		// on the way out
+		return
+	}
+
+	if s.bypassed(z) {
+		return
+	}
+
+	switch behaviour {
+	case "chime":
+		if s.phase == phaseArmed {
+			s.out.chirp()
+		}
+	case "instant":
+		s.trigger(z.Name)
+	case "follower":
+		// follows a delayed zone's entry delay, otherwise trips at once
+		if s.phase != phaseEntry {
+			s.trigger(z.Name)
+		}
+	default: // delayed
+		if s.phase == phaseArmed {
+			s.entry(z.Name)
+		}
+	}
+}
+
+func (s *securitySystem) entry(zone string) {
+	delays, _ := s.delays(s.mode)
+	if delays.Entry == 0 {
+		s.trigger(zone)
+		return
+	}
+
+	s.phase = phaseEntry
+	s.zone = zone
+	delay := time.Duration(delays.Entry) * time.Second
+	s.out.warn(delay)
+	s.schedule(delay, func() { s.trigger(zone) })
+	s.changed()
+}
+
+func (s *securitySystem) trigger(zone string) {
+	s.stop()
+	s.phase = phaseTriggered
+	s.zone = zone
+	s.out.state(characteristic.SecuritySystemCurrentStateAlarmTriggered)
+	s.sound(time.Duration(s.settings.Siren) * time.Second)
+}
+
+// sound starts the siren, stopping it after d if d is not 0
+func (s *securitySystem) sound(d time.Duration) {
+	s.sounding = true
+	s.out.siren(true, s.zone)
+	if d != 0 {
+		s.schedule(d, s.silence)
+	}
+	s.changed()
+}
+
+// silence is called once the siren has sounded long enough
+func (s *securitySystem) silence() {
+	s.sounding = false
+	s.out.siren(false, s.zone)
+	if s.settings.Rearm {
+		s.zone = ""
+		// a 24h zone can trip while disarmed
+		if s.mode == characteristic.SecuritySystemCurrentStateDisarmed {
+			s.phase = phaseDisarmed
+		} else {
+			s.phase = phaseArmed
+		}
+		s.out.state(s.mode)
+	}
+	s.changed()
+}
+
+// lost is called when the board stops answering
+func (s *securitySystem) lost() {
+	s.mu.Lock()
+	defer s.unlock()
+
+	if s.settings.Offline && (s.phase == phaseArmed || s.phase == phaseEntry) {
+		s.trigger("board offline")
+	}
+}
+
+// restore picks up where a previous run left off, delays and the siren carry on for what was left of them
+func (s *securitySystem) restore(st alarmState) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	s.phase = st.Phase
+	s.mode = st.Mode
+	s.zone = st.Zone
+	remaining := st.Deadline.Sub(s.now())
+
+	switch s.phase {
+	case phaseExit:
+		if remaining <= 0 {
+			s.armed()
+			return
+		}
+		s.out.state(characteristic.SecuritySystemCurrentStateDisarmed)
+		s.out.warn(remaining)
+		s.schedule(remaining, s.armed)
+	case phaseEntry:
+		if remaining <= 0 {
+			s.trigger(s.zone)
+			return
+		}
+		s.out.state(s.mode)
+		s.out.warn(remaining)
+		zone := s.zone
+		s.schedule(remaining, func() { s.trigger(zone) })
+	case phaseTriggered:
+		s.out.state(characteristic.SecuritySystemCurrentStateAlarmTriggered)
+		switch {
+		case !st.Sounding:
+			// the siren already stopped, waiting to be disarmed
+		case st.Deadline.IsZero():
+			// sounds until disarmed
+			s.sound(0)
+		case remaining > 0:
+			s.sound(remaining)
+		default:
+			s.silence()
+		}
+		return
+	default:
+		s.out.state(s.mode)
+	}
+	s.changed()
+}
+
+// target is the HomeKit target state
+func (s *securitySystem) target() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mode
+}
+
+// changed saves the state, the lock must be held
+func (s *securitySystem) changed() {
+	s.out.save(alarmState{
+		Phase:    s.phase,
+		Mode:     s.mode,
+		Zone:     s.zone,
+		Sounding: s.sounding,
+		Deadline: s.deadline,
+	})
+}
+
+// current is the HomeKit current state
+func (s *securitySystem) current() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.phase {
+	case phaseTriggered:
+		return characteristic.SecuritySystemCurrentStateAlarmTriggered
+	case phaseExit:
+		return characteristic.SecuritySystemCurrentStateDisarmed
+	default:
+		return s.mode
+	}
+}
+
+func (s *securitySystem) delays(mode int) (tfaccessory.AlarmDelays, bool) {
+	switch mode {
+	case characteristic.SecuritySystemCurrentStateStayArm:
+		return s.settings.Stay, true
+	case characteristic.SecuritySystemCurrentStateAwayArm:
+		return s.settings.Away, true
+	case characteristic.SecuritySystemCurrentStateNightArm:
+		return s.settings.Night, true
+	}
+	return tfaccessory.AlarmDelays{}, false
+}
+
+func (s *securitySystem) bypassed(z tfaccessory.Zone) bool {
+	name := modeName(s.mode)
+	for _, m := range z.Bypass {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// unlock releases the lock and performs the outputs recorded while it was held
+// they are performed in order by whichever caller found nobody else doing it, the others return at once
+func (s *securitySystem) unlock() {
+	if s.flushing {
+		s.mu.Unlock()
+		return
+	}
+	s.flushing = true
+	for len(s.out.q) > 0 {
+		q := s.out.q
+		s.out.q = nil
+		s.mu.Unlock()
+		for _, f := range q {
+			f()
+		}
+		s.mu.Lock()
+	}
+	s.flushing = false
+	s.mu.Unlock()
+}
+
+// schedule runs f with the lock held after d, replacing any running timer
+func (s *securitySystem) schedule(d time.Duration, f func()) {
+	s.stop()
+	gen := s.gen
+	s.deadline = s.now().Add(d)
+	s.timer = s.after(d, func() {
+		s.mu.Lock()
+		defer s.unlock()
+		if s.gen != gen {
+			return
+		}
+		s.timer = nil
+		s.deadline = time.Time{}
+		f()
+	})
+}
+
+func (s *securitySystem) stop() {
+	s.gen++
+	s.deadline = time.Time{}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+func zoneBehaviour(z tfaccessory.Zone) string {
+	if z.Alarm != "" {
+		return z.Alarm
+	}
+	// motion is only a chime unless asked, the cats trip it
+	if z.Type == "motion" {
+		return "chime"
+	}
+	return "delayed"
+}
+
+func modeName(mode int) string {
+	switch mode {
+	case characteristic.SecuritySystemCurrentStateStayArm:
+		return "stay"
+	case characteristic.SecuritySystemCurrentStateAwayArm:
+		return "away"
+	case characteristic.SecuritySystemCurrentStateNightArm:
+		return "night"
+	case characteristic.SecuritySystemCurrentStateDisarmed:
+		return "disarmed"
+	}
+	return "triggered"
+}
@@ -0,0 +1,355 @@
+package konnected
+
+import (
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+
+	"fmt"
+	"github.com/brutella/hc/characteristic"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recorder is the board, it notes what the security system drove
+type recorder struct {
+	events []string
+	saved  alarmState
+	during func() // run inside every output
+}
+
+func (r *recorder) note(e string) {
+	r.events = append(r.events, e)
+	if r.during != nil {
+		r.during()
+	}
+}
+
+func (r *recorder) state(current int)        { r.note("state " + modeName(current)) }
+func (r *recorder) beep()                    { r.note("beep") }
+func (r *recorder) chirp()                   { r.note("chirp") }
+func (r *recorder) warn(delay time.Duration) { r.note("warn " + delay.String()) }
+func (r *recorder) save(st alarmState)       { r.saved = st }
+func (r *recorder) siren(on bool, zone string) {
+	if on {
+		r.note("siren on " + zone)
+	} else {
+		r.note("siren off " + zone)
+	}
+}
+
+// fakeClock stands in for time.AfterFunc and time.Now, timers only run when fired
+type fakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	d       time.Duration
+	f       func()
+	stopped bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.stopped = true
+	return true
+}
+
+func (c *fakeClock) after(d time.Duration, f func()) stopper {
+	t := &fakeTimer{d: d, f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// running is the timer which has not been stopped or fired, nil if there is none
+func (c *fakeClock) running() *fakeTimer {
+	for _, t := range c.timers {
+		if !t.stopped {
+			return t
+		}
+	}
+	return nil
+}
+
+func newTestSystem(settings tfaccessory.AlarmSettings) (*securitySystem, *recorder, *fakeClock) {
+	r := &recorder{}
+	c := &fakeClock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	s := newSecuritySystem(&settings, r)
+	s.after = c.after
+	s.now = func() time.Time { return c.now }
+	return s, r, c
+}
+
+const (
+	stay      = characteristic.SecuritySystemCurrentStateStayArm
+	away      = characteristic.SecuritySystemCurrentStateAwayArm
+	night     = characteristic.SecuritySystemCurrentStateNightArm
+	disarmed  = characteristic.SecuritySystemCurrentStateDisarmed
+	triggered = characteristic.SecuritySystemCurrentStateAlarmTriggered
+)
+
+var (
+	front   = tfaccessory.Zone{Name: "front", Type: "door"}
+	hall    = tfaccessory.Zone{Name: "hall", Type: "motion", Alarm: "follower"}
+	kitchen = tfaccessory.Zone{Name: "kitchen", Type: "motion"}
+	smoke   = tfaccessory.Zone{Name: "smoke", Type: "door", Alarm: "24h"}
+	garage  = tfaccessory.Zone{Name: "garage", Type: "door", Alarm: "instant", Bypass: []string{"stay"}}
+)
+
+var testAlarm = tfaccessory.AlarmSettings{
+	Stay:  tfaccessory.AlarmDelays{Entry: 30},
+	Away:  tfaccessory.AlarmDelays{Entry: 60, Exit: 45},
+	Siren: 300,
+}
+
+// step is something that happens to the security system
+type step func(t *testing.T, s *securitySystem, c *fakeClock)
+
+func arm(mode int) step {
+	return func(t *testing.T, s *securitySystem, c *fakeClock) {
+		if !s.arm(mode) {
+			t.Fatalf("unable to arm %s", modeName(mode))
+		}
+	}
+}
+
+func open(z tfaccessory.Zone) step {
+	return func(t *testing.T, s *securitySystem, c *fakeClock) { s.opened(z) }
+}
+
+func lose(t *testing.T, s *securitySystem, c *fakeClock) { s.lost() }
+
+// fire lets the running delay or siren run out
+func fire(t *testing.T, s *securitySystem, c *fakeClock) {
+	tm := c.running()
+	if tm == nil {
+		t.Fatal("nothing is running")
+	}
+	tm.stopped = true
+	c.now = c.now.Add(tm.d)
+	tm.f()
+}
+
+func TestSecuritySystem(t *testing.T) {
+	rearm := testAlarm
+	rearm.Rearm = true
+	offline := testAlarm
+	offline.Offline = true
+	forever := testAlarm
+	forever.Siren = 0
+
+	tests := []struct {
+		name     string
+		settings tfaccessory.AlarmSettings
+		steps    []step
+		events   []string
+		current  int
+		running  time.Duration // the delay or siren left running, 0 for none
+	}{
+		{"arm at once", testAlarm, []step{arm(stay)},
+			[]string{"state stay", "beep"}, stay, 0},
+		{"exit delay", testAlarm, []step{arm(away)},
+			[]string{"warn 45s"}, disarmed, 45 * time.Second},
+		{"exit delay runs out", testAlarm, []step{arm(away), fire},
+			[]string{"warn 45s", "state away", "beep"}, away, 0},
+		{"leaving during the exit delay", testAlarm, []step{arm(away), open(front), open(hall)},
+			[]string{"warn 45s"}, disarmed, 45 * time.Second},
+		{"disarm during the exit delay", testAlarm, []step{arm(away), arm(disarmed)},
+			[]string{"warn 45s", "siren off ", "state disarmed", "beep"}, disarmed, 0},
+		{"entry delay", testAlarm, []step{arm(stay), open(front)},
+			[]string{"state stay", "beep", "warn 30s"}, stay, 30 * time.Second},
+		{"disarm during the entry delay", testAlarm, []step{arm(stay), open(front), arm(disarmed)},
+			[]string{"state stay", "beep", "warn 30s", "siren off front", "state disarmed", "beep"}, disarmed, 0},
+		{"entry delay runs out", testAlarm, []step{arm(stay), open(front), fire},
+			[]string{"state stay", "beep", "warn 30s", "state triggered", "siren on front"}, triggered, 300 * time.Second},
+		{"no entry delay", testAlarm, []step{arm(night), open(front)},
+			[]string{"state night", "beep", "state triggered", "siren on front"}, triggered, 300 * time.Second},
+		{"follower during the entry delay", testAlarm, []step{arm(stay), open(front), open(hall)},
+			[]string{"state stay", "beep", "warn 30s"}, stay, 30 * time.Second},
+		{"follower alone", testAlarm, []step{arm(stay), open(hall)},
+			[]string{"state stay", "beep", "state triggered", "siren on hall"}, triggered, 300 * time.Second},
+		{"chime armed", testAlarm, []step{arm(stay), open(kitchen)},
+			[]string{"state stay", "beep", "chirp"}, stay, 0},
+		{"door while disarmed", testAlarm, []step{open(front), open(kitchen)},
+			[]string{"chirp"}, disarmed, 0},
+		{"24h while disarmed", testAlarm, []step{open(smoke)},
+			[]string{"state triggered", "siren on smoke"}, triggered, 300 * time.Second},
+		{"24h during the exit delay", testAlarm, []step{arm(away), open(smoke)},
+			[]string{"warn 45s", "state triggered", "siren on smoke"}, triggered, 300 * time.Second},
+		{"bypassed", testAlarm, []step{arm(stay), open(garage)},
+			[]string{"state stay", "beep"}, stay, 0},
+		{"not bypassed", testAlarm, []step{arm(night), open(garage)},
+			[]string{"state night", "beep", "state triggered", "siren on garage"}, triggered, 300 * time.Second},
+		{"siren runs out", testAlarm, []step{arm(night), open(front), fire},
+			[]string{"state night", "beep", "state triggered", "siren on front", "siren off front"}, triggered, 0},
+		{"siren runs out and rearms", rearm, []step{arm(night), open(front), fire},
+			[]string{"state night", "beep", "state triggered", "siren on front", "siren off front", "state night"}, night, 0},
+		{"24h rearms to disarmed", rearm, []step{open(smoke), fire},
+			[]string{"state triggered", "siren on smoke", "siren off smoke", "state disarmed"}, disarmed, 0},
+		{"siren until disarmed", forever, []step{arm(night), open(front)},
+			[]string{"state night", "beep", "state triggered", "siren on front"}, triggered, 0},
+		{"offline while armed", offline, []step{arm(stay), lose},
+			[]string{"state stay", "beep", "state triggered", "siren on board offline"}, triggered, 300 * time.Second},
+		{"offline ignored", testAlarm, []step{arm(stay), lose},
+			[]string{"state stay", "beep"}, stay, 0},
+		{"offline while disarmed", offline, []step{lose},
+			nil, disarmed, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, r, c := newTestSystem(tt.settings)
+			for _, st := range tt.steps {
+				st(t, s, c)
+			}
+
+			if strings.Join(r.events, ", ") != strings.Join(tt.events, ", ") {
+				t.Errorf("got %q, want %q", r.events, tt.events)
+			}
+			if s.current() != tt.current {
+				t.Errorf("current is %s, want %s", modeName(s.current()), modeName(tt.current))
+			}
+			var running time.Duration
+			if tm := c.running(); tm != nil {
+				running = tm.d
+			}
+			if running != tt.running {
+				t.Errorf("%s left running, want %s", running, tt.running)
+			}
+		})
+	}
+}
+
+// only a disarm ends an entry delay or an alarm
+func TestArmRefused(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    int
+		zone    tfaccessory.Zone
+		current int
+		running time.Duration
+	}{
+		{"entry delay", stay, front, stay, 30 * time.Second},
+		{"triggered", night, front, triggered, 300 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, c := newTestSystem(testAlarm)
+			s.arm(tt.mode)
+			s.opened(tt.zone)
+			for _, mode := range []int{stay, away, night} {
+				if s.arm(mode) {
+					t.Errorf("armed %s", modeName(mode))
+				}
+			}
+			if s.target() != tt.mode || s.current() != tt.current {
+				t.Errorf("target %s, current %s", modeName(s.target()), modeName(s.current()))
+			}
+			if tm := c.running(); tm == nil || tm.d != tt.running {
+				t.Errorf("timer %v left running, want %s", tm, tt.running)
+			}
+
+			if !s.arm(characteristic.SecuritySystemTargetStateDisarm) || s.current() != disarmed {
+				t.Errorf("disarm left %s", modeName(s.current()))
+			}
+			if tm := c.running(); tm != nil {
+				t.Errorf("%s left running after the disarm", tm.d)
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	rearm := testAlarm
+	rearm.Rearm = true
+
+	tests := []struct {
+		name     string
+		settings tfaccessory.AlarmSettings
+		saved    alarmState
+		left     time.Duration // from now to the saved deadline, 0 for no deadline
+		events   []string      // on restore
+		current  int
+		running  time.Duration
+		fired    []string // once the running timer fires
+	}{
+		{"disarmed", testAlarm, alarmState{Phase: phaseDisarmed, Mode: disarmed}, 0,
+			[]string{"state disarmed"}, disarmed, 0, nil},
+		{"armed", testAlarm, alarmState{Phase: phaseArmed, Mode: away}, 0,
+			[]string{"state away"}, away, 0, nil},
+		{"mid exit delay", testAlarm, alarmState{Phase: phaseExit, Mode: away}, 20 * time.Second,
+			[]string{"state disarmed", "warn 20s"}, disarmed, 20 * time.Second, []string{"state away", "beep"}},
+		{"exit delay ran out while down", testAlarm, alarmState{Phase: phaseExit, Mode: away}, -5 * time.Second,
+			[]string{"state away", "beep"}, away, 0, nil},
+		{"mid entry delay", testAlarm, alarmState{Phase: phaseEntry, Mode: stay, Zone: "front"}, 10 * time.Second,
+			[]string{"state stay", "warn 10s"}, stay, 10 * time.Second, []string{"state triggered", "siren on front"}},
+		{"entry delay ran out while down", testAlarm, alarmState{Phase: phaseEntry, Mode: stay, Zone: "front"}, -time.Second,
+			[]string{"state triggered", "siren on front"}, triggered, 300 * time.Second, nil},
+		{"siren sounding", testAlarm, alarmState{Phase: phaseTriggered, Mode: night, Zone: "front", Sounding: true}, 100 * time.Second,
+			[]string{"state triggered", "siren on front"}, triggered, 100 * time.Second, []string{"siren off front"}},
+		{"siren until disarmed", testAlarm, alarmState{Phase: phaseTriggered, Mode: night, Zone: "front", Sounding: true}, 0,
+			[]string{"state triggered", "siren on front"}, triggered, 0, nil},
+		{"siren ran out while down", testAlarm, alarmState{Phase: phaseTriggered, Mode: night, Zone: "front", Sounding: true}, -time.Second,
+			[]string{"state triggered", "siren off front"}, triggered, 0, nil},
+		{"siren ran out while down and rearms", rearm, alarmState{Phase: phaseTriggered, Mode: night, Zone: "front", Sounding: true}, -time.Second,
+			[]string{"state triggered", "siren off front", "state night"}, night, 0, nil},
+		{"siren already stopped", testAlarm, alarmState{Phase: phaseTriggered, Mode: night, Zone: "front"}, 0,
+			[]string{"state triggered"}, triggered, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, r, c := newTestSystem(tt.settings)
+			st := tt.saved
+			if tt.left != 0 {
+				st.Deadline = c.now.Add(tt.left)
+			}
+			s.restore(st)
+
+			if strings.Join(r.events, ", ") != strings.Join(tt.events, ", ") {
+				t.Errorf("got %q, want %q", r.events, tt.events)
+			}
+			if s.current() != tt.current {
+				t.Errorf("current is %s, want %s", modeName(s.current()), modeName(tt.current))
+			}
+			tm := c.running()
+			if tt.running == 0 {
+				if tm != nil {
+					t.Errorf("%s left running", tm.d)
+				}
+				return
+			}
+			if tm == nil || tm.d != tt.running {
+				t.Fatalf("want %s running, got %v", tt.running, tm)
+			}
+			// a second restart carries on from the same deadline
+			if !r.saved.Deadline.Equal(c.now.Add(tt.running)) {
+				t.Errorf("saved deadline %s, want %s", r.saved.Deadline, c.now.Add(tt.running))
+			}
+			if tt.fired == nil {
+				return
+			}
+			r.events = nil
+			fire(t, s, c)
+			if strings.Join(r.events, ", ") != strings.Join(tt.fired, ", ") {
+				t.Errorf("once fired got %q, want %q", r.events, tt.fired)
+			}
+		})
+	}
+}
+
+// the outputs talk to the board, they must not hold the lock while they do
+func TestOutputsAfterUnlock(t *testing.T) {
+	s, r, c := newTestSystem(testAlarm)
+	var states []string
+	r.during = func() {
+		// deadlocks if an output runs with the lock held
+		states = append(states, modeName(s.current()))
+	}
+
+	s.arm(away)
+	fire(t, s, c)
+	s.opened(front)
+	s.arm(disarmed)
+
+	if fmt.Sprint(states) != "[disarmed away away away disarmed disarmed disarmed]" {
+		t.Errorf("states seen by the outputs: %v", states)
+	}
+}
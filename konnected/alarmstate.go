@@ -0,0 +1,54 @@
+package konnected
+
+import (
+	"encoding/json"
+	"github.com/brutella/hc/log"
+	"github.com/brutella/hc/util"
+)
+
+// the security system state is saved whenever it changes so a restart does not disarm the house
+
+const alarmSuffix = ".alarm"
+
+var storage util.Storage
+
+func openStorage() {
+	s, err := util.NewFileStorage("konnected")
+	if err != nil {
+		log.Info.Printf("unable to get storage, the security system state will not persist: %s", err.Error())
+		return
+	}
+	storage = s
+}
+
+func saveAlarmState(mac string, st alarmState) {
+	if storage == nil {
+		return
+	}
+	buf, err := json.Marshal(st)
+	if err != nil {
+		log.Info.Println(err.Error())
+		return
+	}
+	if err := storage.Set(macKey(mac)+alarmSuffix, buf); err != nil {
+		log.Info.Println(err.Error())
+	}
+}
+
+// loadAlarmState returns the state saved by the previous run, ok is false if there is none
+func loadAlarmState(mac string) (alarmState, bool) {
+	var st alarmState
+	if storage == nil {
+		return st, false
+	}
+	buf, err := storage.Get(macKey(mac) + alarmSuffix)
+	if err != nil {
+		// never saved
+		return st, false
+	}
+	if err := json.Unmarshal(buf, &st); err != nil {
+		log.Info.Println(err.Error())
+		return st, false
+	}
+	return st, true
+}
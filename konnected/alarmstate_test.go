@@ -0,0 +1,90 @@
+package konnected
+
+import (
+	"fmt"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the state is saved by one run and restored by the next
+func TestAlarmStateRoundTrip(t *testing.T) {
+	s, err := util.NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := storage
+	storage = s
+	defer func() { storage = saved }()
+
+	disarm := func(t *testing.T, s *securitySystem, c *fakeClock) {
+		s.arm(characteristic.SecuritySystemTargetStateDisarm)
+	}
+
+	tests := []struct {
+		name    string
+		steps   []step
+		down    time.Duration // how long the bridge was down
+		events  []string      // on restore
+		current int
+		target  int
+		running time.Duration
+	}{
+		{"armed", []step{arm(night)}, time.Minute,
+			[]string{"state night"}, night, night, 0},
+		{"disarmed", []step{arm(night), disarm}, time.Minute,
+			[]string{"state disarmed"}, disarmed, disarmed, 0},
+		{"mid entry delay", []step{arm(stay), open(front)}, 10 * time.Second,
+			[]string{"state stay", "warn 20s"}, stay, stay, 20 * time.Second},
+		{"triggered", []step{arm(night), open(front)}, 100 * time.Second,
+			[]string{"state triggered", "siren on front"}, triggered, night, 200 * time.Second},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mac := fmt.Sprintf("aa:bb:cc:00:00:3%d", i)
+			before, r, c := newTestSystem(testAlarm)
+			for _, st := range tt.steps {
+				st(t, before, c)
+			}
+			saveAlarmState(mac, r.saved)
+
+			st, ok := loadAlarmState(mac)
+			if !ok {
+				t.Fatal("nothing restored")
+			}
+			after, r, c2 := newTestSystem(testAlarm)
+			c2.now = c.now.Add(tt.down)
+			after.restore(st)
+
+			if strings.Join(r.events, ", ") != strings.Join(tt.events, ", ") {
+				t.Errorf("got %q, want %q", r.events, tt.events)
+			}
+			if after.current() != tt.current || after.target() != tt.target {
+				t.Errorf("current %s, target %s", modeName(after.current()), modeName(after.target()))
+			}
+			var running time.Duration
+			if tm := c2.running(); tm != nil {
+				running = tm.d
+			}
+			if running != tt.running {
+				t.Errorf("%s left running, want %s", running, tt.running)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		if _, ok := loadAlarmState("aa:bb:cc:00:00:3f"); ok {
+			t.Error("restored a state which was never saved")
+		}
+	})
+	t.Run("corrupt", func(t *testing.T) {
+		if err := s.Set("aabbcc00003e"+alarmSuffix, []byte(`{"phase":`)); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := loadAlarmState("aa:bb:cc:00:00:3e"); ok {
+			t.Error("restored a corrupt state")
+		}
+	})
+}
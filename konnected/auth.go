@@ -0,0 +1,115 @@
+package konnected
+
+import (
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/brutella/hc/log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rejected counts the posts the handler turned away, for troubleshooting
+type Rejected struct {
+	UnknownDevice uint64
+	WrongSource   uint64
+	BadToken      uint64
+	BadBody       uint64
+	Last          time.Time
+	LastFrom      string
+	LastReason    string
+}
+
+type rejectedmu struct {
+	mu sync.Mutex
+	r  Rejected
+}
+
+var rejected rejectedmu
+
+// reject counts and logs the post, then answers it
+func reject(w http.ResponseWriter, r *http.Request, code int, counter *uint64, reason string) {
+	count(r, counter, reason)
+	http.Error(w, fmt.Sprintf(`{ "status": %q }`, reason), code)
+}
+
+// ignore counts and logs the post, then acknowledges it without acting on it
+// used for posts from the board itself, refusing them has it retry and then reboot
+func ignore(w http.ResponseWriter, r *http.Request, counter *uint64, reason string) {
+	count(r, counter, reason)
+	fmt.Fprint(w, `{ "status": "OK" }`)
+}
+
+func count(r *http.Request, counter *uint64, reason string) {
+	rejected.mu.Lock()
+	*counter++
+	rejected.r.Last = time.Now()
+	rejected.r.LastFrom = r.RemoteAddr
+	rejected.r.LastReason = reason
+	rejected.mu.Unlock()
+
+	log.Info.Printf("konnected: rejected post from %s to %s: %s", r.RemoteAddr, r.URL.Path, reason)
+}
+
+// fromBoard checks that the post came from the board's address
+func fromBoard(a *tfaccessory.TFAccessory, r *http.Request) bool {
+	src, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	board := ipOf(a)
+	if h, _, err := net.SplitHostPort(board); err == nil {
+		board = h
+	}
+	if board == src {
+		return true
+	}
+
+	// the config may use a name
+	if net.ParseIP(board) != nil {
+		return false
+	}
+	addrs, err := net.LookupHost(board)
+	if err != nil {
+		log.Info.Println(err.Error())
+		return false
+	}
+	for _, addr := range addrs {
+		if addr == src {
+			return true
+		}
+	}
+	return false
+}
+
+// validToken checks the bearer token against the accessory's, boards provisioned without one are not checked
+func validToken(a *tfaccessory.TFAccessory, r *http.Request) bool {
+	if a.Password == "" {
+		return true
+	}
+	const prefix = "Bearer "
+	sent := r.Header.Get("Authorization")
+	if !strings.HasPrefix(sent, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(sent[len(prefix):]), []byte(a.Password)) == 1
+}
+
+// RejectedHandler is registered with the HTTP platform
+// it shows the counts of posts the handler rejected
+func RejectedHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	rejected.mu.Lock()
+	out := rejected.r
+	rejected.mu.Unlock()
+
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		log.Info.Println(err.Error())
+	}
+}
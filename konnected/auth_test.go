@@ -0,0 +1,68 @@
+package konnected
+
+import (
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/platform"
+
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerRejects(t *testing.T) {
+	platform.RegisterPlatform("Konnected", Platform{})
+	board := &tfaccessory.TFAccessory{Name: "board", IP: "192.168.1.20:9123", Password: "token"}
+	kmu.Lock()
+	konnecteds["aabbcc000010"] = board
+	kmu.Unlock()
+	defer func() {
+		kmu.Lock()
+		delete(konnecteds, "aabbcc000010")
+		kmu.Unlock()
+	}()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/konnected/device/{device}", Handler)
+
+	tests := []struct {
+		name    string
+		device  string
+		from    string
+		token   string
+		code    int
+		counter *uint64
+	}{
+		{"unknown device", "aabbcc000011", "192.168.1.20:5555", "token", http.StatusNotFound, &rejected.r.UnknownDevice},
+		{"wrong source", "aabbcc000010", "192.168.1.99:5555", "token", http.StatusUnauthorized, &rejected.r.WrongSource},
+		// the board retries and reboots unless it gets a 200
+		{"wrong token from the board", "aabbcc000010", "192.168.1.20:5555", "stale", http.StatusOK, &rejected.r.BadToken},
+		{"no token from the board", "aabbcc000010", "192.168.1.20:5555", "", http.StatusOK, &rejected.r.BadToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejected.mu.Lock()
+			before := *tt.counter
+			rejected.mu.Unlock()
+
+			r := httptest.NewRequest("PUT", "/konnected/device/"+tt.device, strings.NewReader(`{"pin":1,"state":1}`))
+			r.RemoteAddr = tt.from
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			// the update is dropped before the board's device is touched, it has none here
+			router.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Errorf("answered %d, want %d", w.Code, tt.code)
+			}
+			rejected.mu.Lock()
+			defer rejected.mu.Unlock()
+			if *tt.counter != before+1 {
+				t.Error("not counted")
+			}
+		})
+	}
+}
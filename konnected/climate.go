@@ -0,0 +1,123 @@
+package konnected
+
+import (
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/devices"
+
+	"fmt"
+	"github.com/brutella/hc/log"
+	"reflect"
+)
+
+// how often the board reads the probes if the zone does not say, in minutes
+const defaultPoll = 3
+
+func climateKey(pin uint8, addr string) string {
+	return fmt.Sprintf("%d/%s", pin, addr)
+}
+
+// addClimate registers the service for a temperature or humidity zone
+func addClimate(a *tfaccessory.TFAccessory, z tfaccessory.Zone) {
+	k := a.Device.(*devices.Konnected)
+
+	switch z.Type {
+	case "temperature":
+		addr := z.Address
+		if z.Probe == "dht" {
+			addr = ""
+		}
+		p := devices.NewKonnectedTemperatureSensor(z.Name)
+		k.Temperatures[climateKey(z.Pin, addr)] = p
+		a.Accessory.AddService(p.Service)
+	case "humidity":
+		if z.Probe != "dht" {
+			log.Info.Printf("%s: only DHT probes report humidity", z.Name)
+			return
+		}
+		p := devices.NewKonnectedHumiditySensor(z.Name)
+		k.Humidities[z.Pin] = p
+		a.Accessory.AddService(p.Service)
+	}
+}
+
+// updateClimate takes a reading pushed by the board
+func updateClimate(a *tfaccessory.TFAccessory, r sensor) {
+	k := a.Device.(*devices.Konnected)
+
+	if r.Temp != nil {
+		t, ok := k.Temperatures[climateKey(r.Pin, r.Addr)]
+		if !ok {
+			// zones without an address take any probe on the pin
+			t, ok = k.Temperatures[climateKey(r.Pin, "")]
+		}
+		if ok {
+			t.CurrentTemperature.SetValue(*r.Temp)
+		} else {
+			log.Info.Printf("konnected temperature from unconfigured probe: pin %d %s", r.Pin, r.Addr)
+		}
+	}
+
+	if r.Humidity != nil {
+		if h, ok := k.Humidities[r.Pin]; ok {
+			h.CurrentRelativeHumidity.SetValue(*r.Humidity)
+		} else {
+			log.Info.Printf("konnected humidity from unconfigured probe: pin %d", r.Pin)
+		}
+	}
+}
+
+// probeSettings lists the probe pins from the zones, the way the board's settings want them
+func probeSettings(a *tfaccessory.TFAccessory) ([]probe, []probe) {
+	var ds, dhts []probe
+	seen := make(map[uint8]bool)
+
+	for _, z := range a.KonnectedZones {
+		if (z.Type != "temperature" && z.Type != "humidity") || seen[z.Pin] {
+			continue
+		}
+		seen[z.Pin] = true
+
+		poll := z.Poll
+		if poll == 0 {
+			poll = defaultPoll
+		}
+		switch z.Probe {
+		case "ds18b20":
+			ds = append(ds, probe{Pin: z.Pin, Poll: poll})
+		case "dht":
+			dhts = append(dhts, probe{Pin: z.Pin, Poll: poll})
+		default:
+			log.Info.Printf("%s: unknown probe type: %s", z.Name, z.Probe)
+		}
+	}
+	return ds, dhts
+}
+
+// pushProbeSettings tells the board which pins have probes and how often to read them, if it does not already know
+// the rest of the lists are sent back as the board reported them, in the same full body provisioning sends
+func pushProbeSettings(a *tfaccessory.TFAccessory, details *system) error {
+	ds, dhts := probeSettings(a)
+	if reflect.DeepEqual(ds, details.DBSensors) && reflect.DeepEqual(dhts, details.DHTs) {
+		return nil
+	}
+	if len(ds) == 0 && len(dhts) == 0 && len(details.DBSensors) == 0 && len(details.DHTs) == 0 {
+		return nil
+	}
+
+	// the board does not report its token, sending the settings back without it would clear it and the board's posts would be rejected
+	p := provisioning{
+		settings:  details.Settings,
+		Blink:     !a.KonnectedNoBlink,
+		Discovery: !a.KonnectedNoDiscovery,
+		Actuators: details.Actuators,
+		DBSensors: ds,
+		DHTs:      dhts,
+	}
+	p.Token = a.Password
+	for _, s := range details.Sensors {
+		p.Sensors = append(p.Sensors, sensor{Pin: s.Pin})
+	}
+
+	log.Info.Printf("updating [%s] probe settings", a.Name)
+	return pushProvisioning(a, &p, isPro(a))
+}
@@ -0,0 +1,71 @@
+package konnected
+
+import (
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/config"
+
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPushProbeSettings(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.Path != "/settings" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		buf, _ := ioutil.ReadAll(r.Body)
+		body = string(buf)
+	}))
+	defer srv.Close()
+	config.Set(&config.Config{})
+	client = newClient()
+
+	a := &tfaccessory.TFAccessory{
+		Name:             "board",
+		IP:               strings.TrimPrefix(srv.URL, "http://"),
+		Password:         "token",
+		KonnectedNoBlink: true,
+		KonnectedZones: []tfaccessory.Zone{
+			{Pin: 1, Name: "front", Type: "door"},
+			{Pin: 5, Name: "attic", Type: "temperature", Probe: "ds18b20"},
+		},
+	}
+	details := &system{
+		Settings: settings{EndpointType: "rest", Endpoint: "http://192.168.1.2:8080/konnected"},
+		Sensors:  []sensor{{Pin: 1}},
+	}
+
+	if err := pushProbeSettings(a, details); err != nil {
+		t.Fatal(err)
+	}
+	// the same full body as provisioning, anything left out the board resets
+	for _, want := range []string{
+		`"endpoint_type":"rest"`,
+		`"endpoint":"http://192.168.1.2:8080/konnected"`,
+		`"token":"token"`,
+		`"blink":false`,
+		`"discovery":true`,
+		`"sensors":[{"pin":1,"state":0}]`,
+		`"actuators":[]`,
+		`"ds18b20_sensors":[{"pin":5,"poll_interval":3}]`,
+		`"dht_sensors":[]`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("%s not in %s", want, body)
+		}
+	}
+
+	// nothing is sent when the board already has the probes
+	body = ""
+	details.DBSensors = []probe{{Pin: 5, Poll: 3}}
+	if err := pushProbeSettings(a, details); err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		t.Errorf("sent %s", body)
+	}
+}
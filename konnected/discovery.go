@@ -0,0 +1,224 @@
+package konnected
+
+import (
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/config"
+
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/brutella/hc/log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ssdpAddress = "239.255.255.250:1900"
+
+// how long to wait for boards to answer, boards wait up to MX seconds before answering
+const searchWait = 3 * time.Second
+
+// the search targets of the standard and pro boards
+var searchTargets = []string{
+	"urn:schemas-konnected-io:device:Security:1",
+	"urn:schemas-konnected-io:device:Security:2",
+}
+
+// Discovered is a board which answered discovery
+type Discovered struct {
+	IP        string // host:port of the board's API
+	MAC       string
+	Hardware  string
+	Software  string
+	LastSeen  time.Time
+	Accessory tfaccessory.TFAccessory // a starting point for the accessory config file
+}
+
+// keyed by MAC
+type discoveredmu struct {
+	mu sync.Mutex
+	d  map[string]*Discovered
+}
+
+var discovered = discoveredmu{d: make(map[string]*Discovered)}
+
+// kmu guards konnecteds, their security systems, and the boards' addresses, discovery moves boards while the handler and pullers use them
+var kmu sync.Mutex
+
+// the search started at startup, boards which do not answer where configured wait for it
+var searching sync.WaitGroup
+
+// macKey normalizes the forms the MAC shows up in, the board reports it with colons, the config uses bare hex
+func macKey(mac string) string {
+	return strings.ToLower(strings.Replace(mac, ":", "", -1))
+}
+
+// discover searches for boards, updates the IP of known boards which have moved, and records the rest
+func discover() error {
+	addr := config.Get().KonnectedSSDPAddress
+	if addr == "" {
+		addr = ssdpAddress
+	}
+	dst, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: nil, Port: 0})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, st := range searchTargets {
+		msearch := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: %s\r\nMAN: \"ssdp:discover\"\r\nMX: 2\r\nST: %s\r\n\r\n", ssdpAddress, st)
+		if _, err := conn.WriteToUDP([]byte(msearch), dst); err != nil {
+			return err
+		}
+	}
+
+	locations := make(map[string]bool)
+	buffer := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(searchWait))
+	for {
+		n, _, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			// the deadline passed
+			break
+		}
+		host, ok := parseSearchResponse(buffer[:n])
+		if ok {
+			locations[host] = true
+		}
+	}
+
+	for host := range locations {
+		if err := identify(host); err != nil {
+			log.Info.Println(err.Error())
+		}
+	}
+	return nil
+}
+
+// parseSearchResponse gets the board's API address from the location of its description
+func parseSearchResponse(buf []byte) (string, bool) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf)), nil)
+	if err != nil {
+		return "", false
+	}
+	resp.Body.Close()
+	if !strings.Contains(resp.Header.Get("ST"), "konnected") {
+		return "", false
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || loc.Host == "" {
+		return "", false
+	}
+	return loc.Host, true
+}
+
+// identify reads the board's status and records it
+func identify(host string) error {
+	details, err := getDetails(&tfaccessory.TFAccessory{IP: host})
+	if err != nil {
+		return err
+	}
+	mac := macKey(details.Mac)
+	if mac == "" {
+		return fmt.Errorf("konnected board at %s did not report a MAC", host)
+	}
+
+	kmu.Lock()
+	a, ok := konnecteds[mac]
+	var current string
+	if ok {
+		current = a.IP
+	}
+	kmu.Unlock()
+	if ok {
+		if current != host {
+			rehome(a, mac, current, host, details)
+		}
+		return nil
+	}
+
+	discovered.mu.Lock()
+	defer discovered.mu.Unlock()
+	d, ok := discovered.d[mac]
+	if !ok {
+		log.Info.Printf("discovered konnected board %s at %s", mac, host)
+		d = &Discovered{}
+		discovered.d[mac] = d
+	}
+	d.IP = host
+	d.MAC = mac
+	d.Hardware = details.Hardware
+	d.Software = details.Software
+	d.LastSeen = time.Now()
+	d.Accessory = tfaccessory.TFAccessory{Platform: "Konnected", IP: host, Username: mac}
+	return nil
+}
+
+// rehome moves a configured board to where it answered discovery, if it is no longer where it was
+func rehome(a *tfaccessory.TFAccessory, mac, current, host string, details *system) {
+	// anything can answer a search, the board only moves if it is gone from its configured address
+	if d, err := getDetails(&tfaccessory.TFAccessory{IP: current}); err == nil && macKey(d.Mac) == mac {
+		log.Info.Printf("konnected [%s] answers at %s, ignoring %s which claims to be it", a.Name, current, host)
+		return
+	}
+	// some firmware reports the token, when it does it must be the one the board was given
+	if details.Settings.Token != "" && a.Password != "" && details.Settings.Token != a.Password {
+		log.Info.Printf("konnected [%s]: %s claims to be it but does not have its token, not moving it", a.Name, host)
+		return
+	}
+
+	kmu.Lock()
+	defer kmu.Unlock()
+	// moved by someone else while the old address was tried
+	if a.IP != current {
+		return
+	}
+	log.Info.Printf("konnected [%s] moved from %s to %s", a.Name, current, host)
+	a.IP = host
+}
+
+// discoveredIP is where discovery last saw the board, used when the configured IP does not answer
+func discoveredIP(mac string) (string, bool) {
+	discovered.mu.Lock()
+	defer discovered.mu.Unlock()
+	d, ok := discovered.d[macKey(mac)]
+	if !ok {
+		return "", false
+	}
+	return d.IP, true
+}
+
+// forgetDiscovered is called when a board is configured
+func forgetDiscovered(mac string) {
+	discovered.mu.Lock()
+	delete(discovered.d, macKey(mac))
+	discovered.mu.Unlock()
+}
+
+// DiscoveredHandler is registered with the HTTP platform
+// it lists the boards which have been seen on the network but are not configured
+func DiscoveredHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	discovered.mu.Lock()
+	list := make([]Discovered, 0, len(discovered.d))
+	for _, d := range discovered.d {
+		list = append(list, *d)
+	}
+	discovered.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].IP < list[j].IP })
+
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		log.Info.Println(err.Error())
+	}
+}
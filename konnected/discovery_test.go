@@ -0,0 +1,153 @@
+package konnected
+
+import (
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/config"
+
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeBoard answers /status like a board with the given MAC
+func fakeBoard(mac string) *httptest.Server {
+	return fakeBoardToken(mac, "")
+}
+
+// fakeBoardToken is a board with firmware which reports its token
+func fakeBoardToken(mac, token string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"mac":%q,"hwVersion":"2.3.0","swVersion":"2.3.5","settings":{"token":%q}}`, mac, token)
+	}))
+}
+
+// fakeResponder is an SSDP responder on loopback, it answers every search with the given responses
+func fakeResponder(t *testing.T, responses []string) *net.UDPConn {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(string(buf[:n]), "M-SEARCH") {
+				continue
+			}
+			for _, res := range responses {
+				conn.WriteToUDP([]byte(res), from)
+			}
+		}
+	}()
+	return conn
+}
+
+func searchResponse(st, location string) string {
+	return fmt.Sprintf("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=1800\r\nST: %s\r\nUSN: uuid:fake::%s\r\nLocation: %s\r\n\r\n", st, st, location)
+}
+
+func TestDiscover(t *testing.T) {
+	newBoard := fakeBoard("aa:bb:cc:00:00:01")
+	defer newBoard.Close()
+	movedBoard := fakeBoard("aa:bb:cc:00:00:02")
+	defer movedBoard.Close()
+	router := fakeBoard("aa:bb:cc:00:00:03")
+	defer router.Close()
+	// a board which answers where it is configured, and something else claiming its MAC
+	stayedBoard := fakeBoard("aa:bb:cc:00:00:04")
+	defer stayedBoard.Close()
+	impostor := fakeBoard("aa:bb:cc:00:00:04")
+	defer impostor.Close()
+	// a board which is gone, and something with its MAC but another token
+	wrongToken := fakeBoardToken("aa:bb:cc:00:00:05", "other")
+	defer wrongToken.Close()
+
+	responder := fakeResponder(t, []string{
+		searchResponse(searchTargets[0], newBoard.URL+"/Device.xml"),
+		searchResponse(searchTargets[1], movedBoard.URL+"/Device.xml"),
+		searchResponse("urn:schemas-upnp-org:device:InternetGatewayDevice:1", router.URL+"/rootDesc.xml"),
+		searchResponse(searchTargets[0], impostor.URL+"/Device.xml"),
+		searchResponse(searchTargets[0], wrongToken.URL+"/Device.xml"),
+		"not a response",
+	})
+	defer responder.Close()
+
+	config.Set(&config.Config{KonnectedSSDPAddress: responder.LocalAddr().String()})
+	client = newClient()
+
+	moved := &tfaccessory.TFAccessory{Name: "moved", IP: "127.0.0.1:1"}
+	stayed := &tfaccessory.TFAccessory{Name: "stayed", IP: strings.TrimPrefix(stayedBoard.URL, "http://")}
+	locked := &tfaccessory.TFAccessory{Name: "locked", IP: "127.0.0.1:1", Password: "token"}
+	configured := map[string]*tfaccessory.TFAccessory{"aabbcc000002": moved, "aabbcc000004": stayed, "aabbcc000005": locked}
+	kmu.Lock()
+	for mac, a := range configured {
+		konnecteds[mac] = a
+	}
+	kmu.Unlock()
+	defer func() {
+		kmu.Lock()
+		for mac := range configured {
+			delete(konnecteds, mac)
+		}
+		kmu.Unlock()
+	}()
+
+	if err := discover(); err != nil {
+		t.Fatal(err)
+	}
+
+	host := strings.TrimPrefix(newBoard.URL, "http://")
+	if ip, ok := discoveredIP("AA:BB:CC:00:00:01"); !ok || ip != host {
+		t.Errorf("new board discovered at %q, want %q", ip, host)
+	}
+	if ip := ipOf(moved); ip != strings.TrimPrefix(movedBoard.URL, "http://") {
+		t.Errorf("configured board still at %s", ip)
+	}
+	if ip := ipOf(stayed); ip != strings.TrimPrefix(stayedBoard.URL, "http://") {
+		t.Errorf("board which still answers moved to %s", ip)
+	}
+	if ip := ipOf(locked); ip != "127.0.0.1:1" {
+		t.Errorf("board moved to %s which does not have its token", ip)
+	}
+	for mac := range configured {
+		if _, ok := discoveredIP(mac); ok {
+			t.Errorf("configured board %s listed as discovered", mac)
+		}
+	}
+	if _, ok := discoveredIP("aabbcc000003"); ok {
+		t.Error("a device which is not a konnected board was discovered")
+	}
+}
+
+func TestParseSearchResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		res  string
+		host string
+		ok   bool
+	}{
+		{"board", searchResponse(searchTargets[0], "http://192.168.1.20:9123/Device.xml"), "192.168.1.20:9123", true},
+		{"pro", searchResponse(searchTargets[1], "http://192.168.1.21/Device.xml"), "192.168.1.21", true},
+		{"other device", searchResponse("upnp:rootdevice", "http://192.168.1.1:5000/rootDesc.xml"), "", false},
+		{"no location", "HTTP/1.1 200 OK\r\nST: " + searchTargets[0] + "\r\n\r\n", "", false},
+		{"garbage", "hello", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, ok := parseSearchResponse([]byte(tt.res))
+			if host != tt.host || ok != tt.ok {
+				t.Errorf("got %q %t, want %q %t", host, ok, tt.host, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package konnected
+
+import (
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/config"
+	"github.com/cloudkucooland/toofar/devices"
+
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/log"
+	"sync"
+	"time"
+)
+
+// how long a board can go unheard before it is offline, if the config does not say, in seconds
+const defaultOfflineAfter = 300
+
+// how often to look for boards which have gone quiet
+const healthCheck = 30 * time.Second
+
+// keyed by MAC
+type healthmu struct {
+	mu       sync.Mutex
+	lastSeen map[string]time.Time
+	offline  map[string]bool
+}
+
+var health = healthmu{
+	lastSeen: make(map[string]time.Time),
+	offline:  make(map[string]bool),
+}
+
+// seen is called whenever the board pushes or answers a pull
+func seen(a *tfaccessory.TFAccessory) {
+	mac := macKey(a.Info.SerialNumber)
+
+	health.mu.Lock()
+	health.lastSeen[mac] = time.Now()
+	wasOffline := health.offline[mac]
+	health.offline[mac] = false
+	health.mu.Unlock()
+
+	if wasOffline {
+		log.Info.Printf("konnected [%s] is back", a.Name)
+		setFault(a, false)
+	}
+}
+
+// checkOffline marks the boards which have not been heard from in too long and do not answer
+func checkOffline() {
+	after := time.Duration(config.Get().KonnectedOfflineAfter) * time.Second
+	if after == 0 {
+		after = defaultOfflineAfter * time.Second
+	}
+
+	for mac, a := range boards() {
+		health.mu.Lock()
+		last, ok := health.lastSeen[mac]
+		quiet := ok && !health.offline[mac] && time.Since(last) > after
+		health.mu.Unlock()
+		if !quiet {
+			continue
+		}
+
+		// a closed house with the puller off is quiet too, ask before tripping the alarm
+		err := getStatusAndUpdate(a)
+		if err == nil {
+			continue
+		}
+		log.Info.Println(err.Error())
+
+		health.mu.Lock()
+		lost := health.lastSeen[mac].Equal(last) && !health.offline[mac]
+		if lost {
+			health.offline[mac] = true
+		}
+		health.mu.Unlock()
+		if !lost {
+			// it posted while being asked
+			continue
+		}
+
+		log.Info.Printf("konnected [%s] not heard from since %s", a.Name, last.Format(time.RFC3339))
+		setFault(a, true)
+		if ss, ok := systemOf(mac); ok {
+			ss.lost()
+		}
+	}
+}
+
+// setFault marks every sensor on the board, an unreachable board might have been tampered with
+func setFault(a *tfaccessory.TFAccessory, fault bool) {
+	f := characteristic.StatusFaultNoFault
+	t := characteristic.StatusTamperedNotTampered
+	if fault {
+		f = characteristic.StatusFaultGeneralFault
+		t = characteristic.StatusTamperedTampered
+	}
+
+	k := a.Device.(*devices.Konnected)
+	k.SecuritySystem.StatusFault.SetValue(f)
+	k.SecuritySystem.StatusTampered.SetValue(t)
+	for _, p := range k.Pins {
+		switch p.(type) {
+		case *devices.KonnectedContactSensor:
+			p.(*devices.KonnectedContactSensor).StatusFault.SetValue(f)
+			p.(*devices.KonnectedContactSensor).StatusTampered.SetValue(t)
+		case *devices.KonnectedMotionSensor:
+			p.(*devices.KonnectedMotionSensor).StatusFault.SetValue(f)
+			p.(*devices.KonnectedMotionSensor).StatusTampered.SetValue(t)
+		}
+	}
+	for _, p := range k.Temperatures {
+		p.StatusFault.SetValue(f)
+	}
+	for _, p := range k.Humidities {
+		p.StatusFault.SetValue(f)
+	}
+}
@@ -0,0 +1,73 @@
+package konnected
+
+import (
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/config"
+	"github.com/cloudkucooland/toofar/devices"
+
+	"fmt"
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckOffline(t *testing.T) {
+	config.Set(&config.Config{})
+	client = newClient()
+
+	tests := []struct {
+		name    string
+		answers bool
+		offline bool
+	}{
+		{"quiet but answers", true, false},
+		{"gone", false, true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `[{"pin":1,"state":0}]`)
+			}))
+			if !tt.answers {
+				// nothing listening
+				srv.Close()
+			} else {
+				defer srv.Close()
+			}
+
+			mac := fmt.Sprintf("aabbcc00002%d", i)
+			a := &tfaccessory.TFAccessory{Name: tt.name, IP: strings.TrimPrefix(srv.URL, "http://")}
+			a.Info = accessory.Info{Name: tt.name, SerialNumber: mac}
+			a.Device = devices.NewKonnected(a.Info)
+			kmu.Lock()
+			konnecteds[mac] = a
+			kmu.Unlock()
+			defer func() {
+				kmu.Lock()
+				delete(konnecteds, mac)
+				kmu.Unlock()
+			}()
+
+			health.mu.Lock()
+			health.lastSeen[mac] = time.Now().Add(-time.Hour)
+			health.mu.Unlock()
+
+			checkOffline()
+
+			health.mu.Lock()
+			offline := health.offline[mac]
+			health.mu.Unlock()
+			if offline != tt.offline {
+				t.Errorf("offline %t, want %t", offline, tt.offline)
+			}
+			fault := a.Device.(*devices.Konnected).SecuritySystem.StatusFault.GetValue() != characteristic.StatusFaultNoFault
+			if fault != tt.offline {
+				t.Errorf("fault %t, want %t", fault, tt.offline)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	tfaccessory "github.com/cloudkucooland/toofar/accessory"
 	"github.com/cloudkucooland/toofar/config"
 	"github.com/cloudkucooland/toofar/devices"
+	"github.com/cloudkucooland/toofar/noonlight"
 	"github.com/cloudkucooland/toofar/platform"
 
 	"bytes"
@@ -17,68 +18,79 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
-	"sync"
 	"time"
 )
 
 type system struct {
 	Mac       string     `json:"mac"`
-	IP        string     `json:"ip",omitempty`
-	Gateway   string     `json:"gw",omitempty`
-	Netmask   string     `json:"nm",omitempty`
-	Hardware  string     `json:"hwVersion",omitempty`
-	RSSI      int8       `json:"rssi",omitempty`
-	Software  string     `json:"swVersion",omitempty`
-	Port      uint16     `json:"port",omitempty`
-	Uptime    uint64     `json:"uptime",omitempty`
-	Heap      uint64     `json:"heap",omitempty`
+	IP        string     `json:"ip,omitempty"`
+	Gateway   string     `json:"gw,omitempty"`
+	Netmask   string     `json:"nm,omitempty"`
+	Hardware  string     `json:"hwVersion,omitempty"`
+	RSSI      int8       `json:"rssi,omitempty"`
+	Software  string     `json:"swVersion,omitempty"`
+	Port      uint16     `json:"port,omitempty"`
+	Uptime    uint64     `json:"uptime,omitempty"`
+	Heap      uint64     `json:"heap,omitempty"`
 	Settings  settings   `json:"settings"`
 	Sensors   []sensor   `json:"sensors"`
-	DBSensors []sensor   `json:"ds18b20_sensors"`
+	DBSensors []probe    `json:"ds18b20_sensors"`
 	Actuators []actuator `json:"actuators"`
-	DHTs      []dht      `json:"dht_sensors"`
+	DHTs      []probe    `json:"dht_sensors"`
 }
 
 type settings struct {
-	EndpointType string `json:"endpoint_type",omitempty`
-	Endpoint     string `json:"endpoint",omitempty`
-	Token        string `json:"token",omitempty`
+	EndpointType string `json:"endpoint_type,omitempty"`
+	Endpoint     string `json:"endpoint,omitempty"`
+	Token        string `json:"token,omitempty"`
 }
 
 type sensor struct {
-	Pin   uint8 `json:"pin"`
-	State uint8 `json:"state"`
-	Retry uint8 `json:"retry",omitempty`
+	Pin   uint8  `json:"pin,omitempty"`
+	Zone  string `json:"zone,omitempty"` // Pro boards
+	State uint8  `json:"state"`
+	Retry uint8  `json:"retry,omitempty"`
+
+	// Pro supervised inputs: "tamper" or "fault"
+	Trouble string `json:"trouble,omitempty"`
+
+	// temperature and humidity pushes
+	Temp     *float64 `json:"temp,omitempty"`
+	Humidity *float64 `json:"humi,omitempty"`
+	Addr     string   `json:"addr,omitempty"`
 }
 
 type actuator struct {
-	Pin     uint8 `json:"pin"`
-	Trigger uint8 `json:"trigger"`
+	Pin     uint8  `json:"pin,omitempty"`
+	Zone    string `json:"zone,omitempty"` // Pro boards
+	Trigger uint8  `json:"trigger"`
 }
 
-type dht struct {
-	Pin  uint8 `json:"pin"`
-	Poll uint  `json:"poll_interval"`
+// ds18b20 and dht sensors
+type probe struct {
+	Pin  uint8  `json:"pin,omitempty"`
+	Zone string `json:"zone,omitempty"` // Pro boards
+	Poll uint   `json:"poll_interval"`  // minutes
 }
 
 type command struct {
-	Pin       uint8  `json:"pin"`
+	Pin       uint8  `json:"pin,omitempty"`
+	Zone      string `json:"zone,omitempty"` // Pro boards
 	State     uint8  `json:"state"`
-	Momentary uint16 `json:"state",omitempty`
-	Times     uint8  `json:"times",omitempty`
-	Pause     uint8  `json:"pause",omitempty`
+	Momentary uint16 `json:"momentary,omitempty"`
+	Times     uint8  `json:"times,omitempty"`
+	Pause     uint16 `json:"pause,omitempty"`
 }
 
 // Handler is registered with the HTTP platform
 // it listens for Konnected devices and respond appropriately
 // if the board doesn't get a 200 in response, it retries, and failing several retries, it reboots
-// we will just say OK no matter what for now
+// so only posts which are not from a configured board are refused, the board's own posts with a bad token are acknowledged and dropped
 func Handler(w http.ResponseWriter, r *http.Request) {
 	s, ok := platform.GetPlatform("Konnected")
 	if !ok {
 		log.Info.Print("unable to get konnected platform, giving up")
-		// http.Error(w, `{ "status": "bad" }`, http.StatusInternalServerError)
-		// acknowledge so it doesn't retransmit
+		// acknowledge so it doesn't retransmit, rebooting the board will not help
 		fmt.Fprint(w, `{ "status": "OK" }`)
 		return
 	}
@@ -87,34 +99,23 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	device := vars["device"]
 	a, ok := s.GetAccessory(device)
 	if !ok {
-		log.Info.Printf("konnected state from unknown device (%s / %s), ignoring", r.RemoteAddr, device)
-		// http.Error(w, `{ "status": "bad" }`, http.StatusNotAcceptable)
-		fmt.Fprint(w, `{ "status": "OK" }`)
+		reject(w, r, http.StatusNotFound, &rejected.r.UnknownDevice, "unknown device")
 		return
 	}
-
-	// verify token, if set in local config
-	if a.Password != "" {
-		sentToken := r.Header.Get("Authorization")
-		if sentToken == "" {
-			log.Info.Printf("Authorization token not sent")
-			// http.Error(w, `{ "status": "bad" }`, http.StatusForbidden)
-			fmt.Fprint(w, `{ "status": "OK" }`)
-			return
-		}
-		if sentToken[7:] != a.Password {
-			log.Info.Printf("Authorization token invalid")
-			// http.Error(w, `{ "status": "bad" }`, http.StatusForbidden)
-			fmt.Fprint(w, `{ "status": "OK" }`)
-			return
-		}
+	if !fromBoard(a, r) {
+		reject(w, r, http.StatusUnauthorized, &rejected.r.WrongSource, fmt.Sprintf("not from %s", ipOf(a)))
+		return
 	}
+	if !validToken(a, r) {
+		// from the board, but not provisioned with the current token
+		ignore(w, r, &rejected.r.BadToken, "invalid token")
+		return
+	}
+	seen(a)
 
 	jBlob, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Info.Printf("konnected: unable to read update")
-		// http.Error(w, `{ "status": "bad" }`, http.StatusInternalServerError)
-		fmt.Fprint(w, `{ "status": "OK" }`)
+		reject(w, r, http.StatusBadRequest, &rejected.r.BadBody, "unable to read update")
 		return
 	}
 	// if konnected provisioned with a trailing / on the url..
@@ -134,8 +135,13 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	// log.Info.Printf("sent from %+v: %s", a.Name, string(jBlob))
 	err = json.Unmarshal(jBlob, &p)
 	if err != nil {
-		log.Info.Printf("konnected: unable to understand update")
-		// http.Error(w, `{ "status": "bad" }`, http.StatusNotAcceptable)
+		reject(w, r, http.StatusBadRequest, &rejected.r.BadBody, "unable to understand update")
+		return
+	}
+	fromWire(&p.Pin, &p.Zone)
+
+	if p.Temp != nil || p.Humidity != nil {
+		updateClimate(a, p)
 		fmt.Fprint(w, `{ "status": "OK" }`)
 		return
 	}
@@ -145,39 +151,31 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		switch svc.(type) {
 		case *devices.KonnectedMotionSensor:
 			svc.(*devices.KonnectedMotionSensor).MotionDetected.SetValue(p.State == 1)
-			switch a.Device.(*devices.Konnected).SecuritySystem.SecuritySystemCurrentState.GetValue() {
-			case characteristic.SecuritySystemCurrentStateDisarmed:
-				// nothing
-			case characteristic.SecuritySystemCurrentStateStayArm:
-				// doorchirps(a)
-			default:
-				// for now we won't do anything since the cats trip it
-				log.Info.Println("motion detected while alarm armed; pin: %d", p.Pin)
-				doorchirps(a)
+			if isPro(a) {
+				updateTrouble(svc, p.Trouble)
+			}
+			if p.State == 1 {
+				zoneOpened(a, p.Pin)
 			}
 		case *devices.KonnectedContactSensor:
 			svc.(*devices.KonnectedContactSensor).ContactSensorState.SetValue(int(p.State))
-			switch a.Device.(*devices.Konnected).SecuritySystem.SecuritySystemCurrentState.GetValue() {
-			case characteristic.SecuritySystemCurrentStateAwayArm:
-				countdownAlarm(a)
-			case characteristic.SecuritySystemCurrentStateNightArm:
-				instantAlarm(a)
-			case characteristic.SecuritySystemCurrentStateStayArm:
-				// nothing for now
-				doorchirps(a)
-			default:
-				doorchirps(a)
+			if isPro(a) {
+				updateTrouble(svc, p.Trouble)
+			}
+			if p.State == 1 {
+				zoneOpened(a, p.Pin)
 			}
 			state := "opened"
 			if p.State == 0 {
 				state = "closed"
 			}
 			log.Info.Printf("%s: %s", svc.(*devices.KonnectedContactSensor).Name.GetValue(), state)
-		case *devices.KonnectedBuzzer: // not used
+		case *devices.KonnectedBuzzer:
 			svc.(*devices.KonnectedBuzzer).Active.SetValue(int(p.State))
+		case *devices.KonnectedSwitch, *devices.KonnectedGarageDoor, *devices.KonnectedValve:
+			updateActuator(svc, p.Pin, p.State)
 		default:
-			log.Info.Println("bad type in handler: %+v", svc)
-			doorchirps(a)
+			log.Info.Printf("bad type in handler: %+v", svc)
 		}
 	}
 	fmt.Fprint(w, `{ "status": "OK" }`)
@@ -188,15 +186,28 @@ type Platform struct {
 	Running bool
 }
 
-var konnecteds map[string]*tfaccessory.TFAccessory
-var doOnce sync.Once
+var konnecteds = make(map[string]*tfaccessory.TFAccessory)
 var client *http.Client
-var disarmed chan (bool)
+var systems = make(map[string]*securitySystem)
 
 // Startup is called by the platform management to get things going
 func (s Platform) Startup(c *config.Config) platform.Control {
 	s.Running = true
+	client = newClient()
+	openStorage()
+
+	// find boards while they are added, in case DHCP has moved them
+	searching.Add(1)
+	go func() {
+		defer searching.Done()
+		if err := discover(); err != nil {
+			log.Info.Println(err.Error())
+		}
+	}()
+	return s
+}
 
+func newClient() *http.Client {
 	timeout := config.Get().KonnectedTimeout
 	if timeout == 0 {
 		timeout = 10
@@ -206,10 +217,7 @@ func (s Platform) Startup(c *config.Config) platform.Control {
 		MaxIdleConns:    5,
 		IdleConnTimeout: 30 * time.Second,
 	}
-	client = &http.Client{Transport: tr, Timeout: time.Second * time.Duration(timeout)}
-
-	disarmed = make(chan bool)
-	return s
+	return &http.Client{Transport: tr, Timeout: time.Second * time.Duration(timeout)}
 }
 
 // Shutdown is called by the platform management to shut things down
@@ -220,13 +228,19 @@ func (s Platform) Shutdown() platform.Control {
 
 // AddAccessory adds a Konnected device and registers it with HC
 func (s Platform) AddAccessory(a *tfaccessory.TFAccessory) {
-	doOnce.Do(func() {
-		konnecteds = make(map[string]*tfaccessory.TFAccessory)
-	})
-
 	a.Type = accessory.TypeSecuritySystem
+	normalizeZones(a)
 
 	details, err := getDetails(a)
+	if err != nil {
+		// the search may yet find it somewhere else
+		searching.Wait()
+	}
+	if ip, ok := discoveredIP(a.Username); err != nil && ok && ip != a.IP {
+		log.Info.Printf("konnected [%s] not at %s, using discovered address %s", a.Name, a.IP, ip)
+		a.IP = ip
+		details, err = getDetails(a)
+	}
 	if err != nil {
 		log.Info.Printf("unable to identify Konnected device: %s", err.Error())
 		return
@@ -236,6 +250,7 @@ func (s Platform) AddAccessory(a *tfaccessory.TFAccessory) {
 	a.Info.Manufacturer = "Konnected.io"
 	a.Info.Model = details.Hardware
 	a.Info.FirmwareRevision = details.Software
+	seen(a)
 
 	// convert the Mac address into a uint64 for the ID
 	mac, err := hex.DecodeString(a.Username) // details.Mac
@@ -247,10 +262,14 @@ func (s Platform) AddAccessory(a *tfaccessory.TFAccessory) {
 	}
 
 	// konnecteds are indexed by device IDs
-	konnecteds[a.Info.SerialNumber] = a
+	kmu.Lock()
+	konnecteds[macKey(a.Info.SerialNumber)] = a
+	kmu.Unlock()
+	forgetDiscovered(a.Info.SerialNumber)
 
 	a.Device = devices.NewKonnected(a.Info)
 	a.Accessory = a.Device.(*devices.Konnected).Accessory
+	a.Device.(*devices.Konnected).Pro = isProHardware(details.Hardware)
 
 	for _, v := range a.KonnectedZones {
 		switch v.Type {
@@ -264,13 +283,19 @@ func (s Platform) AddAccessory(a *tfaccessory.TFAccessory) {
 			a.Device.(*devices.Konnected).Pins[v.Pin] = p
 			a.Accessory.AddService(p.Service)
 			log.Info.Printf("Konnected Pin: %d: %s (contact)", v.Pin, v.Name)
-		case "buzzer": // not used
+		case "buzzer":
 			p := devices.NewKonnectedBuzzer(v.Name)
 			a.Device.(*devices.Konnected).Pins[v.Pin] = p
 			a.Accessory.AddService(p.Service)
 			log.Info.Printf("Konnected Pin: %d: %s (buzzer)", v.Pin, v.Name)
+		case "switch", "siren", "strobe", "garage", "valve":
+			addActuator(a, v)
+			log.Info.Printf("Konnected Pin: %d: %s (%s)", v.Pin, v.Name, v.Type)
+		case "temperature", "humidity":
+			addClimate(a, v)
+			log.Info.Printf("Konnected Pin: %d: %s (%s %s)", v.Pin, v.Name, v.Probe, v.Type)
 		default:
-			log.Info.Println("unknown KonnectedZone type")
+			log.Info.Printf("unknown KonnectedZone type: %s", v.Type)
 		}
 	}
 
@@ -283,48 +308,56 @@ func (s Platform) AddAccessory(a *tfaccessory.TFAccessory) {
 				p.(*devices.KonnectedMotionSensor).MotionDetected.SetValue(v.State == 1)
 			case *devices.KonnectedBuzzer:
 				p.(*devices.KonnectedBuzzer).Active.SetValue(int(v.State))
+			case *devices.KonnectedSwitch, *devices.KonnectedGarageDoor, *devices.KonnectedValve:
+				updateActuator(p, v.Pin, v.State)
 			default:
 				log.Info.Println("unknown konnected device type")
 			}
 		}
 	}
+	// /status only lists the actuators' trigger levels, their states are read from the pins
+	if status, err := getStatus(a); err != nil {
+		log.Info.Println(err.Error())
+	} else {
+		for _, v := range *status {
+			if p, ok := a.Device.(*devices.Konnected).Pins[v.Pin]; ok {
+				switch p.(type) {
+				case *devices.KonnectedSwitch, *devices.KonnectedGarageDoor, *devices.KonnectedValve:
+					updateActuator(p, v.Pin, v.State)
+				}
+			}
+		}
+	}
+	if config.Get().KonnectedProvision {
+		err = provisionIfChanged(a, details)
+	} else {
+		// the board only starts reading probes once told to
+		err = pushProbeSettings(a, details)
+	}
+	if err != nil {
+		log.Info.Println(err.Error())
+	}
 
+	ss := newSecuritySystem(a.KonnectedAlarm, boardAlarm{a})
+	kmu.Lock()
+	systems[macKey(a.Info.SerialNumber)] = ss
+	kmu.Unlock()
+	// before HC starts, so HomeKit never sees the house disarmed after a restart
+	if st, ok := loadAlarmState(a.Info.SerialNumber); ok {
+		log.Info.Printf("restoring [%s] security system: %s", a.Name, modeName(st.Mode))
+		ss.restore(st)
+	}
+	a.Device.(*devices.Konnected).SecuritySystem.SecuritySystemTargetState.SetValue(ss.target())
 	a.Device.(*devices.Konnected).SecuritySystem.SecuritySystemTargetState.OnValueRemoteUpdate(func(newval int) {
 		log.Info.Printf("HC requested system state change to %d", newval)
-		triggered := true
-		if a.Device.(*devices.Konnected).SecuritySystem.SecuritySystemCurrentState.GetValue() !=
-			characteristic.SecuritySystemCurrentStateAlarmTriggered {
-			triggered = false
-		}
-		switch newval {
-		case characteristic.SecuritySystemCurrentStateStayArm:
-			if triggered {
-				log.Info.Println("not changing while in triggered state")
-				return
-			}
-		case characteristic.SecuritySystemCurrentStateAwayArm:
-			if triggered {
-				log.Info.Println("not changing while in triggered state")
-				return
-			}
-		case characteristic.SecuritySystemCurrentStateNightArm:
-			if triggered {
-				log.Info.Println("not changing while in triggered state")
-				return
-			}
-		case characteristic.SecuritySystemCurrentStateDisarmed:
-			if triggered {
-				log.Info.Println("shutting off alarm")
-				cancelAlarm(a)
-			}
-		default:
-			log.Info.Printf("unknown security system state: %d", newval)
+		if !ss.arm(newval) {
+			log.Info.Printf("unable to change to %d from %d, disarm first", newval, ss.current())
+			// HomeKit would otherwise show the new target while still triggered
+			a.Device.(*devices.Konnected).SecuritySystem.SecuritySystemTargetState.SetValue(ss.target())
 			return
 		}
-		a.Device.(*devices.Konnected).SecuritySystem.SecuritySystemCurrentState.SetValue(newval)
-		// let a triggered alarm continue to ring until cancelAlarm takes care of it
-		if !triggered {
-			beep(a)
+		if newval == characteristic.SecuritySystemTargetStateDisarm {
+			noonlight.Cancel(a.Name)
 		}
 	})
 
@@ -356,12 +389,40 @@ func doRequest(a *tfaccessory.TFAccessory, method, url string, buf io.Reader) (*
 }
 
 func (s Platform) GetAccessory(mac string) (*tfaccessory.TFAccessory, bool) {
-	val, ok := konnecteds[mac]
+	kmu.Lock()
+	defer kmu.Unlock()
+	val, ok := konnecteds[macKey(mac)]
 	return val, ok
 }
 
+// boards lists the configured boards, keyed by MAC
+func boards() map[string]*tfaccessory.TFAccessory {
+	kmu.Lock()
+	defer kmu.Unlock()
+	out := make(map[string]*tfaccessory.TFAccessory, len(konnecteds))
+	for mac, a := range konnecteds {
+		out[mac] = a
+	}
+	return out
+}
+
+// systemOf is the board's security system, there is none until the board is added
+func systemOf(mac string) (*securitySystem, bool) {
+	kmu.Lock()
+	defer kmu.Unlock()
+	ss, ok := systems[macKey(mac)]
+	return ss, ok
+}
+
+// ipOf is where the board is, discovery changes it when the board moves
+func ipOf(a *tfaccessory.TFAccessory) string {
+	kmu.Lock()
+	defer kmu.Unlock()
+	return a.IP
+}
+
 func getDetails(a *tfaccessory.TFAccessory) (*system, error) {
-	url := fmt.Sprintf("http://%s/status", a.IP)
+	url := fmt.Sprintf("http://%s/status", ipOf(a))
 	body, err := doRequest(a, "GET", url, nil)
 	if err != nil {
 		return nil, err
@@ -371,12 +432,12 @@ func getDetails(a *tfaccessory.TFAccessory) (*system, error) {
 	if err := json.Unmarshal(*body, &s); err != nil {
 		return nil, err
 	}
+	s.fromWire()
 	return &s, nil
 }
 
 func getStatus(a *tfaccessory.TFAccessory) (*[]sensor, error) {
-	url := fmt.Sprintf("http://%s/device", a.IP)
-	body, err := doRequest(a, "GET", url, nil)
+	body, err := doRequest(a, "GET", deviceURL(a), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -385,6 +446,9 @@ func getStatus(a *tfaccessory.TFAccessory) (*[]sensor, error) {
 	if err := json.Unmarshal(*body, &s); err != nil {
 		return nil, err
 	}
+	for i := range s {
+		fromWire(&s[i].Pin, &s[i].Zone)
+	}
 	return &s, nil
 }
 
@@ -393,16 +457,29 @@ func getStatusAndUpdate(a *tfaccessory.TFAccessory) error {
 	if err != nil {
 		return err
 	}
+	seen(a)
 
 	for _, v := range *status {
 		if p, ok := a.Device.(*devices.Konnected).Pins[v.Pin]; ok {
 			switch p.(type) {
 			case *devices.KonnectedMotionSensor:
 				p.(*devices.KonnectedMotionSensor).MotionDetected.SetValue(v.State == 1)
+				if isPro(a) {
+					updateTrouble(p, v.Trouble)
+				}
 			case *devices.KonnectedContactSensor:
+				if isPro(a) {
+					updateTrouble(p, v.Trouble)
+				}
 				if p.(*devices.KonnectedContactSensor).ContactSensorState.GetValue() != int(v.State) {
 					p.(*devices.KonnectedContactSensor).ContactSensorState.SetValue(int(v.State))
+					// missed the push
+					if v.State == 1 {
+						zoneOpened(a, v.Pin)
+					}
 				}
+			case *devices.KonnectedSwitch, *devices.KonnectedGarageDoor, *devices.KonnectedValve:
+				updateActuator(p, v.Pin, v.State)
 			default:
 				log.Info.Printf("konnected device not processed: pin %d", v.Pin)
 			}
@@ -412,6 +489,22 @@ func getStatusAndUpdate(a *tfaccessory.TFAccessory) error {
 }
 
 func (k Platform) Background() {
+	go func() {
+		for range time.Tick(healthCheck) {
+			checkOffline()
+		}
+	}()
+
+	if kdr := config.Get().KonnectedDiscoveryRate; kdr != 0 {
+		go func() {
+			for range time.Tick(time.Second * time.Duration(kdr)) {
+				if err := discover(); err != nil {
+					log.Info.Println(err.Error())
+				}
+			}
+		}()
+	}
+
 	kpr := config.Get().KonnectedPullRate
 	if kpr == 0 {
 		log.Info.Println("pull rate set to 0, disabling konnected puller")
@@ -424,7 +517,7 @@ func (k Platform) Background() {
 }
 
 func (k Platform) backgroundPuller() {
-	for _, a := range konnecteds {
+	for _, a := range boards() {
 		err := getStatusAndUpdate(a)
 		if err != nil {
 			log.Info.Println(err.Error())
@@ -433,82 +526,104 @@ func (k Platform) backgroundPuller() {
 	}
 }
 
-func beep(a *tfaccessory.TFAccessory) {
-	if a.Device.(*devices.Konnected).SecuritySystem.SecuritySystemCurrentState.GetValue() !=
-		characteristic.SecuritySystemCurrentStateAlarmTriggered {
-		doBuzz(a, `"state":1, "momentary":120, "times":2, "pause":55`, characteristic.ActiveInactive)
-	} else {
-		log.Info.Println("not beeping since in triggered state")
+// zoneOpened hands a tripped zone to the board's security system
+func zoneOpened(a *tfaccessory.TFAccessory, pin uint8) {
+	ss, ok := systemOf(a.Info.SerialNumber)
+	if !ok {
+		return
+	}
+	for _, z := range a.KonnectedZones {
+		if z.Pin != pin || (z.Type != "motion" && z.Type != "door") {
+			continue
+		}
+		// let the responders know where things are happening
+		if ss.current() == characteristic.SecuritySystemCurrentStateAlarmTriggered {
+			if z.Type == "door" {
+				noonlight.ZoneEvent(a.Name, z.Name, "contact", "open")
+			} else {
+				noonlight.ZoneEvent(a.Name, z.Name, "motion", "detected")
+			}
+		}
+		ss.opened(z)
+		return
 	}
 }
 
-func doorchirps(a *tfaccessory.TFAccessory) {
-	if a.Device.(*devices.Konnected).SecuritySystem.SecuritySystemCurrentState.GetValue() !=
-		characteristic.SecuritySystemCurrentStateAlarmTriggered {
-		doBuzz(a, `"state":1, "momentary":10, "times":5, "pause":30`, characteristic.ActiveInactive)
-	} else {
-		log.Info.Println("not doing chirps since in triggered state")
-	}
+// boardAlarm drives the board's buzzer, sirens, and HomeKit state for its security system
+type boardAlarm struct {
+	a *tfaccessory.TFAccessory
 }
 
-func instantAlarm(a *tfaccessory.TFAccessory) {
-	a.Device.(*devices.Konnected).SecuritySystem.SecuritySystemCurrentState.SetValue(characteristic.SecuritySystemCurrentStateAlarmTriggered)
-	log.Info.Println("sending alarm")
-	doBuzz(a, `"state":1`, characteristic.ActiveActive)
+func (b boardAlarm) state(current int) {
+	log.Info.Printf("[%s] security system now %s", b.a.Name, modeName(current))
+	b.a.Device.(*devices.Konnected).SecuritySystem.SecuritySystemCurrentState.SetValue(current)
+}
 
-	// notify noonlight
+func (b boardAlarm) beep() {
+	b.buzz(`"state":1, "momentary":120, "times":2, "pause":55`, characteristic.ActiveInactive)
+}
 
-	go func() {
-		select {
-		case <-disarmed:
-			// cancelAlarm called
-			// send all-clear to noonlight
-			beep(a)
-		case <-time.After(5 * time.Minute):
-			beep(a) // no point of ringing for longer
-		}
-	}()
+func (b boardAlarm) chirp() {
+	b.buzz(`"state":1, "momentary":10, "times":5, "pause":30`, characteristic.ActiveInactive)
 }
 
-func countdownAlarm(a *tfaccessory.TFAccessory) {
-	log.Info.Println("starting countdown")
-	a.Device.(*devices.Konnected).SecuritySystem.SecuritySystemCurrentState.SetValue(characteristic.SecuritySystemCurrentStateAlarmTriggered)
+func (b boardAlarm) warn(delay time.Duration) {
+	// a short pulse every half second until the delay is up
+	b.buzz(fmt.Sprintf(`"state":1, "momentary":50, "times":%d, "pause":450`, delay/(500*time.Millisecond)), characteristic.ActiveInactive)
+}
 
-	doBuzz(a, `"state":1, "momentary":50, "pause":450`, characteristic.ActiveInactive)
+func (b boardAlarm) siren(on bool, zone string) {
+	if on {
+		log.Info.Printf("[%s] alarm triggered by %s", b.a.Name, zone)
+		noonlight.Alarm(b.a.Name, zone)
+		b.buzz(`"state":1`, characteristic.ActiveActive)
+	} else {
+		b.buzz(`"state":0`, characteristic.ActiveInactive)
+	}
 
-	go func() {
-		select {
-		case <-disarmed:
-			// cancelAlarm called
-		case <-time.After(1 * time.Minute):
-			instantAlarm(a)
+	for _, z := range b.a.KonnectedZones {
+		if z.Type != "siren" && z.Type != "strobe" {
+			continue
 		}
-	}()
+		if err := actuate(b.a, z, on); err != nil {
+			log.Info.Println(err.Error())
+			continue
+		}
+		if sw, ok := b.a.Device.(*devices.Konnected).Pins[z.Pin].(*devices.KonnectedSwitch); ok {
+			sw.On.SetValue(on)
+		}
+	}
 }
 
-func getBuzzerPin(a *tfaccessory.TFAccessory) uint8 {
-	// TBD do the work...
-	return 8
+func (b boardAlarm) save(st alarmState) {
+	saveAlarmState(b.a.Info.SerialNumber, st)
 }
 
-func getBuzzer(a *tfaccessory.TFAccessory) *devices.KonnectedBuzzer {
-	pin := getBuzzerPin(a)
-	if svc, ok := a.Device.(*devices.Konnected).Pins[pin]; ok {
-		return svc.(*devices.KonnectedBuzzer)
+func (b boardAlarm) buzz(cmd string, hcstate int) {
+	if err := doBuzz(b.a, cmd, hcstate); err != nil {
+		log.Info.Println(err.Error())
 	}
-	return nil
 }
 
-func cancelAlarm(a *tfaccessory.TFAccessory) {
-	if a.Device.(*devices.Konnected).SecuritySystem.SecuritySystemCurrentState.GetValue() ==
-		characteristic.SecuritySystemCurrentStateDisarmed {
-		log.Info.Println("not triggered, nothing to cancel")
-		return
+// getBuzzerPin finds the first buzzer zone, ok is false if the board does not have one
+func getBuzzerPin(a *tfaccessory.TFAccessory) (uint8, bool) {
+	for _, z := range a.KonnectedZones {
+		if z.Type == "buzzer" {
+			return z.Pin, true
+		}
 	}
+	return 0, false
+}
 
-	doBuzz(a, `"state": 0`, characteristic.ActiveInactive)
-	disarmed <- true
-	a.Device.(*devices.Konnected).SecuritySystem.SecuritySystemCurrentState.SetValue(characteristic.SecuritySystemCurrentStateDisarmed)
+func getBuzzer(a *tfaccessory.TFAccessory) *devices.KonnectedBuzzer {
+	pin, ok := getBuzzerPin(a)
+	if !ok {
+		return nil
+	}
+	if svc, ok := a.Device.(*devices.Konnected).Pins[pin]; ok {
+		return svc.(*devices.KonnectedBuzzer)
+	}
+	return nil
 }
 
 func doBuzz(a *tfaccessory.TFAccessory, cmd string, hcstate int) error {
@@ -516,10 +631,13 @@ func doBuzz(a *tfaccessory.TFAccessory, cmd string, hcstate int) error {
 		buzzer.Active.SetValue(hcstate)
 	}
 
-	pin := getBuzzerPin(a)
-	url := fmt.Sprintf("http://%s/device", a.IP)
-	fullcmd := fmt.Sprintf("{\"pin\":%d, %s}", pin, cmd)
-	_, err := doRequest(a, "PUT", url, bytes.NewBuffer([]byte(fullcmd)))
+	pin, ok := getBuzzerPin(a)
+	if !ok {
+		log.Info.Printf("[%s] has no buzzer zone", a.Name)
+		return nil
+	}
+	fullcmd := fmt.Sprintf("{%s, %s}", pinField(a, pin), cmd)
+	_, err := doRequest(a, "PUT", deviceURL(a), bytes.NewBuffer([]byte(fullcmd)))
 	if err != nil {
 		return err
 	}
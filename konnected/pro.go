@@ -0,0 +1,162 @@
+package konnected
+
+import (
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/devices"
+
+	"fmt"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/log"
+	"strconv"
+	"strings"
+)
+
+// the Konnected Pro (ESP32) numbers its zones rather than using GPIO pins, and says "zone" where the original says "pin"
+// internally the zones are given pin numbers so both boards share the same maps and zone config
+
+// indexed by the pin number used internally
+var proZones = []string{"", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "alarm1", "out1", "alarm2_out2"}
+
+// isProHardware picks the settings schema from the hwVersion in /status, the ESP32 boards are 3.0 and up
+func isProHardware(hw string) bool {
+	major, err := strconv.Atoi(strings.SplitN(hw, ".", 2)[0])
+	return err == nil && major >= 3
+}
+
+func isPro(a *tfaccessory.TFAccessory) bool {
+	k, ok := a.Device.(*devices.Konnected)
+	return ok && k.Pro
+}
+
+func zonePin(zone string) (uint8, bool) {
+	for i, z := range proZones {
+		if i != 0 && z == zone {
+			return uint8(i), true
+		}
+	}
+	return 0, false
+}
+
+func pinZone(pin uint8) string {
+	if int(pin) < len(proZones) {
+		return proZones[pin]
+	}
+	return ""
+}
+
+// normalizeZones gives the zones which are configured by Pro zone name their pin number
+func normalizeZones(a *tfaccessory.TFAccessory) {
+	for i, z := range a.KonnectedZones {
+		if z.Zone == "" {
+			continue
+		}
+		pin, ok := zonePin(z.Zone)
+		if !ok {
+			log.Info.Printf("%s: unknown Konnected Pro zone: %s", z.Name, z.Zone)
+			continue
+		}
+		a.KonnectedZones[i].Pin = pin
+	}
+}
+
+// deviceURL is where the board takes commands and reports states
+func deviceURL(a *tfaccessory.TFAccessory) string {
+	if isPro(a) {
+		return fmt.Sprintf("http://%s/zone", ipOf(a))
+	}
+	return fmt.Sprintf("http://%s/device", ipOf(a))
+}
+
+// fromWire moves a Pro zone name to the pin number
+func fromWire(pin *uint8, zone *string) {
+	if *zone == "" {
+		return
+	}
+	if p, ok := zonePin(*zone); ok {
+		*pin = p
+	}
+	*zone = ""
+}
+
+// toWire moves the pin number to the zone name for a Pro board
+func toWire(pro bool, pin *uint8, zone *string) {
+	if !pro {
+		return
+	}
+	*zone = pinZone(*pin)
+	*pin = 0
+}
+
+func (s *system) fromWire() {
+	for i := range s.Sensors {
+		fromWire(&s.Sensors[i].Pin, &s.Sensors[i].Zone)
+	}
+	for i := range s.Actuators {
+		fromWire(&s.Actuators[i].Pin, &s.Actuators[i].Zone)
+	}
+	for i := range s.DBSensors {
+		fromWire(&s.DBSensors[i].Pin, &s.DBSensors[i].Zone)
+	}
+	for i := range s.DHTs {
+		fromWire(&s.DHTs[i].Pin, &s.DHTs[i].Zone)
+	}
+}
+
+// toWire copies the settings with the lists in the board's schema
+func (p provisioning) toWire(pro bool) provisioning {
+	out := p
+	out.Sensors = make([]sensor, len(p.Sensors))
+	for i, s := range p.Sensors {
+		toWire(pro, &s.Pin, &s.Zone)
+		out.Sensors[i] = s
+	}
+	out.Actuators = make([]actuator, len(p.Actuators))
+	for i, s := range p.Actuators {
+		toWire(pro, &s.Pin, &s.Zone)
+		out.Actuators[i] = s
+	}
+	out.DBSensors = make([]probe, len(p.DBSensors))
+	for i, s := range p.DBSensors {
+		toWire(pro, &s.Pin, &s.Zone)
+		out.DBSensors[i] = s
+	}
+	out.DHTs = make([]probe, len(p.DHTs))
+	for i, s := range p.DHTs {
+		toWire(pro, &s.Pin, &s.Zone)
+		out.DHTs[i] = s
+	}
+	return out
+}
+
+// pinField is the start of a command for the pin, in the board's schema
+func pinField(a *tfaccessory.TFAccessory, pin uint8) string {
+	if isPro(a) {
+		return fmt.Sprintf(`"zone":%q`, pinZone(pin))
+	}
+	return fmt.Sprintf(`"pin":%d`, pin)
+}
+
+// updateTrouble shows the supervised input status of a Pro zone, a cut wire is tampering, a shorted one a fault
+func updateTrouble(svc interface{}, trouble string) {
+	fault := characteristic.StatusFaultNoFault
+	tampered := characteristic.StatusTamperedNotTampered
+	switch trouble {
+	case "":
+	case "tamper":
+		tampered = characteristic.StatusTamperedTampered
+	case "fault":
+		fault = characteristic.StatusFaultGeneralFault
+	default:
+		log.Info.Printf("unknown supervised input status: %s", trouble)
+		return
+	}
+
+	switch svc.(type) {
+	case *devices.KonnectedContactSensor:
+		svc.(*devices.KonnectedContactSensor).StatusFault.SetValue(fault)
+		svc.(*devices.KonnectedContactSensor).StatusTampered.SetValue(tampered)
+	case *devices.KonnectedMotionSensor:
+		svc.(*devices.KonnectedMotionSensor).StatusFault.SetValue(fault)
+		svc.(*devices.KonnectedMotionSensor).StatusTampered.SetValue(tampered)
+	}
+}
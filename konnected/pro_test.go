@@ -0,0 +1,139 @@
+package konnected
+
+import (
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+
+	"encoding/json"
+	"testing"
+)
+
+func TestZonePin(t *testing.T) {
+	tests := []struct {
+		zone string
+		pin  uint8
+		ok   bool
+	}{
+		{"1", 1, true},
+		{"9", 9, true},
+		{"12", 12, true},
+		{"alarm1", 13, true},
+		{"out1", 14, true},
+		{"alarm2_out2", 15, true},
+		{"", 0, false},
+		{"0", 0, false},
+		{"13", 0, false},
+		{"alarm2", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.zone, func(t *testing.T) {
+			pin, ok := zonePin(tt.zone)
+			if pin != tt.pin || ok != tt.ok {
+				t.Errorf("got %d %t, want %d %t", pin, ok, tt.pin, tt.ok)
+			}
+			if ok && pinZone(pin) != tt.zone {
+				t.Errorf("pin %d back to zone %q", pin, pinZone(pin))
+			}
+		})
+	}
+
+	if z := pinZone(uint8(len(proZones))); z != "" {
+		t.Errorf("pin past the zones is zone %q", z)
+	}
+}
+
+func TestFromWire(t *testing.T) {
+	tests := []struct {
+		name string
+		pin  uint8
+		zone string
+		want uint8
+	}{
+		{"original board", 5, "", 5},
+		{"pro zone", 0, "7", 7},
+		{"pro output", 0, "out1", 14},
+		{"unknown zone", 3, "bogus", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pin, zone := tt.pin, tt.zone
+			fromWire(&pin, &zone)
+			if pin != tt.want || zone != "" {
+				t.Errorf("got %d %q, want %d", pin, zone, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeZones(t *testing.T) {
+	a := &tfaccessory.TFAccessory{KonnectedZones: []tfaccessory.Zone{
+		{Pin: 2, Name: "by pin"},
+		{Zone: "3", Name: "by zone"},
+		{Zone: "alarm2_out2", Name: "siren"},
+		{Pin: 4, Zone: "bogus", Name: "unknown zone"},
+	}}
+	normalizeZones(a)
+
+	want := []uint8{2, 3, 15, 4}
+	for i, z := range a.KonnectedZones {
+		if z.Pin != want[i] {
+			t.Errorf("%s: pin %d, want %d", z.Name, z.Pin, want[i])
+		}
+	}
+}
+
+func TestIsProHardware(t *testing.T) {
+	tests := []struct {
+		hw  string
+		pro bool
+	}{
+		{"2.3.0", false},
+		{"2", false},
+		{"3.0.1", true},
+		{"4", true},
+		{"", false},
+		{"unknown", false},
+	}
+	for _, tt := range tests {
+		if isProHardware(tt.hw) != tt.pro {
+			t.Errorf("%q: pro %t, want %t", tt.hw, !tt.pro, tt.pro)
+		}
+	}
+}
+
+func TestProvisioningToWire(t *testing.T) {
+	p := provisioning{
+		settings:  settings{EndpointType: "rest", Endpoint: "http://192.168.1.2:8080/konnected", Token: "token"},
+		Blink:     true,
+		Discovery: true,
+		Sensors:   []sensor{{Pin: 1}, {Pin: 12}},
+		Actuators: []actuator{{Pin: 15, Trigger: 1}},
+		DBSensors: []probe{{Pin: 5, Poll: 3}},
+	}
+
+	tests := []struct {
+		name string
+		pro  bool
+		want string
+	}{
+		{"original board", false, `{"endpoint_type":"rest","endpoint":"http://192.168.1.2:8080/konnected","token":"token","blink":true,"discovery":true,` +
+			`"sensors":[{"pin":1,"state":0},{"pin":12,"state":0}],"actuators":[{"pin":15,"trigger":1}],"ds18b20_sensors":[{"pin":5,"poll_interval":3}],"dht_sensors":[]}`},
+		{"pro", true, `{"endpoint_type":"rest","endpoint":"http://192.168.1.2:8080/konnected","token":"token","blink":true,"discovery":true,` +
+			`"sensors":[{"zone":"1","state":0},{"zone":"12","state":0}],"actuators":[{"zone":"alarm2_out2","trigger":1}],"ds18b20_sensors":[{"zone":"5","poll_interval":3}],"dht_sensors":[]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := json.Marshal(p.toWire(tt.pro))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != tt.want {
+				t.Errorf("got  %s\nwant %s", buf, tt.want)
+			}
+		})
+	}
+
+	// the settings are copied, not changed
+	if p.Sensors[0].Pin != 1 || p.Sensors[0].Zone != "" {
+		t.Errorf("original changed: %+v", p.Sensors[0])
+	}
+}
@@ -0,0 +1,299 @@
+package konnected
+
+import (
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/config"
+	"github.com/cloudkucooland/toofar/platform"
+
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/brutella/hc/log"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// provisioning is the full /settings payload
+type provisioning struct {
+	settings
+	Blink     bool       `json:"blink"`
+	Discovery bool       `json:"discovery"`
+	Sensors   []sensor   `json:"sensors"`
+	Actuators []actuator `json:"actuators"`
+	DBSensors []probe    `json:"ds18b20_sensors"`
+	DHTs      []probe    `json:"dht_sensors"`
+}
+
+// Provision pushes the settings from the accessory config to the board, generating a token if there is not one
+func Provision(a *tfaccessory.TFAccessory) error {
+	if client == nil {
+		client = newClient()
+	}
+	normalizeZones(a)
+
+	// the schema depends on the board
+	details, err := getDetails(a)
+	if err != nil {
+		return err
+	}
+
+	p, err := provisioningFor(a)
+	if err != nil {
+		return err
+	}
+	return pushProvisioning(a, p, isProHardware(details.Hardware))
+}
+
+// provisionIfChanged is used at startup, the board restarts when given new settings so only send them if needed
+func provisionIfChanged(a *tfaccessory.TFAccessory, details *system) error {
+	generate := a.Password == ""
+	p, err := provisioningFor(a)
+	if err != nil {
+		return err
+	}
+	if !generate && sameProvisioning(p, details) {
+		return nil
+	}
+	return pushProvisioning(a, p, isPro(a))
+}
+
+func pushProvisioning(a *tfaccessory.TFAccessory, p *provisioning, pro bool) error {
+	// this also gives empty lists rather than nulls, which the board wants
+	buf, err := json.Marshal(p.toWire(pro))
+	if err != nil {
+		return err
+	}
+	log.Info.Printf("provisioning [%s] to send to %s", a.Name, p.Endpoint)
+	url := fmt.Sprintf("http://%s/settings", ipOf(a))
+	_, err = doRequest(a, "PUT", url, bytes.NewBuffer(buf))
+	return err
+}
+
+// provisioningFor builds the board's settings from the zones
+func provisioningFor(a *tfaccessory.TFAccessory) (*provisioning, error) {
+	endpoint, err := endpointFor(a)
+	if err != nil {
+		return nil, err
+	}
+
+	// the token is saved before the board gets it, otherwise a failed save would lock us out of the board
+	if a.Password == "" {
+		token, err := newToken()
+		if err != nil {
+			return nil, err
+		}
+		a.Password = token
+		if err := saveToken(a); err != nil {
+			a.Password = ""
+			return nil, err
+		}
+	}
+
+	p := provisioning{
+		settings: settings{
+			EndpointType: "rest",
+			Endpoint:     endpoint,
+			Token:        a.Password,
+		},
+		Blink:     !a.KonnectedNoBlink,
+		Discovery: !a.KonnectedNoDiscovery,
+	}
+
+	seen := make(map[uint8]bool)
+	addSensor := func(pin uint8) {
+		if !seen[pin] {
+			seen[pin] = true
+			p.Sensors = append(p.Sensors, sensor{Pin: pin})
+		}
+	}
+	for _, z := range a.KonnectedZones {
+		trigger := uint8(1)
+		if z.ActiveLow {
+			trigger = 0
+		}
+		switch z.Type {
+		case "motion", "door":
+			addSensor(z.Pin)
+		case "garage":
+			addSensor(z.Sensor)
+			p.Actuators = append(p.Actuators, actuator{Pin: z.Pin, Trigger: trigger})
+		case "buzzer", "switch", "siren", "strobe", "valve":
+			p.Actuators = append(p.Actuators, actuator{Pin: z.Pin, Trigger: trigger})
+		}
+	}
+	p.DBSensors, p.DHTs = probeSettings(a)
+
+	return &p, nil
+}
+
+// sameProvisioning compares the settings with what the board reported, the board does not report its token
+func sameProvisioning(p *provisioning, details *system) bool {
+	if p.EndpointType != details.Settings.EndpointType || p.Endpoint != details.Settings.Endpoint {
+		return false
+	}
+	if details.Settings.Token != "" && p.Token != details.Settings.Token {
+		return false
+	}
+
+	var sensors []uint8
+	for _, s := range details.Sensors {
+		sensors = append(sensors, s.Pin)
+	}
+	var want []uint8
+	for _, s := range p.Sensors {
+		want = append(want, s.Pin)
+	}
+	sortPins(sensors)
+	sortPins(want)
+
+	actuators := append([]actuator{}, details.Actuators...)
+	wantActuators := append([]actuator{}, p.Actuators...)
+	sort.Slice(actuators, func(i, j int) bool { return actuators[i].Pin < actuators[j].Pin })
+	sort.Slice(wantActuators, func(i, j int) bool { return wantActuators[i].Pin < wantActuators[j].Pin })
+
+	return reflect.DeepEqual(sensors, want) &&
+		reflect.DeepEqual(actuators, wantActuators) &&
+		reflect.DeepEqual(p.DBSensors, details.DBSensors) &&
+		reflect.DeepEqual(p.DHTs, details.DHTs)
+}
+
+func sortPins(pins []uint8) {
+	sort.Slice(pins, func(i, j int) bool { return pins[i] < pins[j] })
+}
+
+// endpointFor builds the URL the board sends to from config.HTTPAddress
+// if that does not name a host, use the address this host reaches the board from
+func endpointFor(a *tfaccessory.TFAccessory) (string, error) {
+	host, port, err := net.SplitHostPort(config.Get().HTTPAddress)
+	if err != nil {
+		return "", err
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		board := ipOf(a)
+		if h, _, err := net.SplitHostPort(board); err == nil {
+			board = h
+		}
+		// UDP does not send anything, this only asks the kernel for the route
+		conn, err := net.Dial("udp", net.JoinHostPort(board, "80"))
+		if err != nil {
+			return "", err
+		}
+		host = conn.LocalAddr().(*net.UDPAddr).IP.String()
+		conn.Close()
+	}
+
+	return fmt.Sprintf("http://%s/konnected", net.JoinHostPort(host, port)), nil
+}
+
+func newToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// saveToken writes the generated token into the accessory's config file
+// only the password is touched, the rest of the file is left as it was written
+func saveToken(a *tfaccessory.TFAccessory) error {
+	if a.ConfigFile == "" {
+		return fmt.Errorf("[%s] has no config file to save the token in", a.Name)
+	}
+
+	fi, err := os.Stat(a.ConfigFile)
+	if err != nil {
+		return err
+	}
+	raw, err := ioutil.ReadFile(a.ConfigFile)
+	if err != nil {
+		return err
+	}
+	buf, err := setPassword(raw, a.Password)
+	if err != nil {
+		return fmt.Errorf("%s: %s", a.ConfigFile, err.Error())
+	}
+	log.Info.Printf("saving generated token for [%s] to %s", a.Name, a.ConfigFile)
+	return ioutil.WriteFile(a.ConfigFile, buf, fi.Mode())
+}
+
+// setPassword replaces the value of the top level Password in the JSON object, or adds it as the first field
+func setPassword(raw []byte, password string) ([]byte, error) {
+	value, err := json.Marshal(password)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("not a JSON object")
+	}
+	open := int(dec.InputOffset())
+
+	first := -1
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := t.(string)
+		if first < 0 {
+			// the key's opening quote, after any space following the brace
+			first = open + len(raw[open:]) - len(bytes.TrimLeft(raw[open:], " \t\r\n"))
+		}
+
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		// the loader does not care about case, neither do we
+		if strings.EqualFold(key, "Password") {
+			end := int(dec.InputOffset())
+			start := end - len(v)
+			return append(append(append([]byte{}, raw[:start]...), value...), raw[end:]...), nil
+		}
+	}
+
+	if first < 0 {
+		// an empty object
+		return append(append(append([]byte{}, raw[:open]...), `"Password": `+string(value)...), raw[open:]...), nil
+	}
+	// laid out like the field which follows it
+	field := append([]byte(`"Password": `+string(value)+","), raw[open:first]...)
+	return append(append(append([]byte{}, raw[:first]...), field...), raw[first:]...), nil
+}
+
+// ProvisionHandler is registered with the HTTP platform, a POST pushes the settings to the board
+func ProvisionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if r.Method != http.MethodPost {
+		http.Error(w, `{ "status": "unsupported method" }`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	s, ok := platform.GetPlatform("Konnected")
+	if !ok {
+		http.Error(w, `{ "status": "konnected not running" }`, http.StatusInternalServerError)
+		return
+	}
+	a, ok := s.GetAccessory(mux.Vars(r)["device"])
+	if !ok {
+		http.Error(w, `{ "status": "unknown device" }`, http.StatusNotFound)
+		return
+	}
+
+	if err := Provision(a); err != nil {
+		log.Info.Println(err.Error())
+		http.Error(w, fmt.Sprintf(`{ "status": %q }`, err.Error()), http.StatusBadGateway)
+		return
+	}
+	fmt.Fprint(w, `{ "status": "OK" }`)
+}
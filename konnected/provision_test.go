@@ -0,0 +1,38 @@
+package konnected
+
+import (
+	"testing"
+)
+
+func TestSetPassword(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{"replaced in place", "{\n  \"Name\": \"board\",\n  \"Password\": \"\",\n  \"IP\": \"192.168.1.20:9123\"\n}\n",
+			"{\n  \"Name\": \"board\",\n  \"Password\": \"token\",\n  \"IP\": \"192.168.1.20:9123\"\n}\n"},
+		{"any case", "{\"name\":\"board\",\"password\":null}", "{\"name\":\"board\",\"password\":\"token\"}"},
+		{"added first", "{\n    \"Name\": \"board\",\n    \"IP\": \"192.168.1.20:9123\"\n}\n",
+			"{\n    \"Password\": \"token\",\n    \"Name\": \"board\",\n    \"IP\": \"192.168.1.20:9123\"\n}\n"},
+		{"nested passwords left alone", "{\"Noonlight\":{\"Password\":\"x\"}}", "{\"Password\": \"token\",\"Noonlight\":{\"Password\":\"x\"}}"},
+		{"empty", "{}", "{\"Password\": \"token\"}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := setPassword([]byte(tt.in), "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.out {
+				t.Errorf("got %q, want %q", out, tt.out)
+			}
+		})
+	}
+
+	for _, bad := range []string{"", "[]", "{\"Name\":"} {
+		if _, err := setPassword([]byte(bad), "token"); err == nil {
+			t.Errorf("%q did not fail", bad)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package noonlight
+
+import (
+	"encoding/json"
+	"github.com/brutella/hc/log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// every dispatch and cancel is appended to the audit log, one JSON record per line, so there is a record of what was sent and why
+const defaultAuditLog = "noonlight/audit.log"
+
+type auditRecord struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"` // dispatch, event, cancel, status
+	Source string    `json:"source,omitempty"`
+	Zone   string    `json:"zone,omitempty"`
+	Alarm  string    `json:"alarm,omitempty"` // the Noonlight alarm ID
+	Status string    `json:"status,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+var auditmu sync.Mutex
+
+// audit records the action, err is the result of sending it to Noonlight
+func audit(r auditRecord, err error) {
+	r.Time = time.Now()
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	path := defaultAuditLog
+	if noonlight != nil && noonlight.Noonlight.AuditLog != "" {
+		path = noonlight.Noonlight.AuditLog
+	}
+
+	buf, jerr := json.Marshal(r)
+	if jerr != nil {
+		log.Info.Println(jerr.Error())
+		return
+	}
+
+	auditmu.Lock()
+	defer auditmu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Info.Println(err.Error())
+		return
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Info.Println(err.Error())
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(buf, '\n')); err != nil {
+		log.Info.Println(err.Error())
+	}
+}
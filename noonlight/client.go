@@ -0,0 +1,159 @@
+package noonlight
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SandboxURL is used unless the accessory config says otherwise, nobody gets dispatched from the sandbox
+const SandboxURL = "https://api-sandbox.noonlight.com"
+
+// Client talks to the Noonlight dispatch API
+type Client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// AlarmRequest is what Noonlight needs to send help
+type AlarmRequest struct {
+	Name         string             `json:"name"`
+	Phone        string             `json:"phone"`
+	PIN          string             `json:"pin,omitempty"`
+	Location     Location           `json:"location"`
+	Services     map[string]bool    `json:"services"`
+	Instructions *AlarmInstructions `json:"instructions,omitempty"`
+}
+
+// Location of the alarm
+type Location struct {
+	Address tfaccessory.NoonlightAddress `json:"address"`
+}
+
+// AlarmInstructions are passed on to the responders
+type AlarmInstructions struct {
+	Entry string `json:"entry"`
+}
+
+// CreatedAlarm is Noonlight's record of an alarm
+type CreatedAlarm struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Event is something which happened after the alarm was created, such as another door opening
+type Event struct {
+	Type string    `json:"event_type"`
+	Time time.Time `json:"event_time"`
+	Meta EventMeta `json:"meta"`
+}
+
+// EventMeta describes the device the event came from
+type EventMeta struct {
+	Attribute    string `json:"attribute"` // contact, motion, ...
+	Value        string `json:"value"`
+	DeviceID     string `json:"device_id"`
+	DeviceModel  string `json:"device_model"`
+	DeviceName   string `json:"device_name"`
+	Manufacturer string `json:"device_manufacturer"`
+}
+
+// NewClient makes a client for the API at base, unset for the sandbox
+func NewClient(base, token string) *Client {
+	if base == "" {
+		base = SandboxURL
+	}
+	return &Client{
+		base:  strings.TrimSuffix(base, "/"),
+		token: token,
+		http:  &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// CreateAlarm asks Noonlight to start calling
+func (c *Client) CreateAlarm(req AlarmRequest) (*CreatedAlarm, error) {
+	var a CreatedAlarm
+	if err := c.post("/dispatch/v1/alarms", req, &a); err != nil {
+		return nil, err
+	}
+	if a.ID == "" {
+		return nil, fmt.Errorf("noonlight did not return an alarm ID")
+	}
+	return &a, nil
+}
+
+// Events adds to a created alarm
+func (c *Client) Events(id string, events []Event) error {
+	return c.post(fmt.Sprintf("/dispatch/v1/alarms/%s/events", id), events, nil)
+}
+
+// Status asks Noonlight where the alarm is, ACTIVE, CANCELED, ...
+func (c *Client) Status(id string) (string, error) {
+	var out struct {
+		Status string `json:"status"`
+	}
+	if err := c.do("GET", fmt.Sprintf("/dispatch/v1/alarms/%s/status", id), nil, &out); err != nil {
+		return "", err
+	}
+	return out.Status, nil
+}
+
+// Cancel ends the alarm, it needs the PIN the alarm was created with
+func (c *Client) Cancel(id, pin string) error {
+	req := struct {
+		Status string `json:"status"`
+		PIN    string `json:"pin"`
+	}{"CANCELED", pin}
+	return c.post(fmt.Sprintf("/dispatch/v1/alarms/%s/status", id), req, nil)
+}
+
+func (c *Client) post(path string, in interface{}, out interface{}) error {
+	return c.do("POST", path, in, out)
+}
+
+// do sends in, if not nil, and decodes the response into out, if not nil
+func (c *Client) do(method, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewBuffer(buf)
+	}
+
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("noonlight %s: %s: %s", path, resp.Status, string(respBody))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
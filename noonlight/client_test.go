@@ -0,0 +1,267 @@
+package noonlight
+
+import (
+	"encoding/json"
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	tfaccessory "github.com/cloudkucooland/toofar/accessory"
+	"github.com/cloudkucooland/toofar/devices"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type request struct {
+	method string
+	path   string
+	auth   string
+	body   string
+}
+
+// fakeNoonlight is the dispatch API, it records what it is sent
+type fakeNoonlight struct {
+	mu       sync.Mutex
+	requests []request
+	fail     bool // answer everything with a 500
+}
+
+func (f *fakeNoonlight) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, request{r.Method, r.URL.Path, r.Header.Get("Authorization"), string(body)})
+	if f.fail {
+		http.Error(w, `{"message":"down"}`, http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/dispatch/v1/alarms":
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"alarm-1","status":"ACTIVE","created_at":"2020-01-01T12:00:00Z"}`))
+	case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/status"):
+		w.Write([]byte(`{"status":"ACTIVE"}`))
+	default:
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (f *fakeNoonlight) sent() []request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]request{}, f.requests...)
+}
+
+func TestClient(t *testing.T) {
+	f := &fakeNoonlight{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	c := NewClient(srv.URL+"/", "secret")
+
+	a, err := c.CreateAlarm(AlarmRequest{Name: "Owner", Phone: "5555550100", PIN: "1234", Services: map[string]bool{"police": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ID != "alarm-1" || a.Status != "ACTIVE" {
+		t.Errorf("unexpected alarm: %+v", a)
+	}
+	if err := c.Events(a.ID, []Event{zoneEvent("house", "front", "alarm.device.value_changed", "contact", "open")}); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := c.Status(a.ID); err != nil || status != "ACTIVE" {
+		t.Errorf("status %q, %v", status, err)
+	}
+	if err := c.Cancel(a.ID, "1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   []string // in the body
+	}{
+		{"POST", "/dispatch/v1/alarms", []string{`"name":"Owner"`, `"phone":"5555550100"`, `"pin":"1234"`, `"services":{"police":true}`}},
+		{"POST", "/dispatch/v1/alarms/alarm-1/events", []string{`[{"event_type":"alarm.device.value_changed"`, `"device_id":"house/front"`, `"attribute":"contact"`, `"value":"open"`}},
+		{"GET", "/dispatch/v1/alarms/alarm-1/status", nil},
+		{"POST", "/dispatch/v1/alarms/alarm-1/status", []string{`"status":"CANCELED"`, `"pin":"1234"`}},
+	}
+	sent := f.sent()
+	if len(sent) != len(tests) {
+		t.Fatalf("sent %d requests, want %d", len(sent), len(tests))
+	}
+	for i, tt := range tests {
+		r := sent[i]
+		if r.method != tt.method || r.path != tt.path {
+			t.Errorf("request %d: %s %s, want %s %s", i, r.method, r.path, tt.method, tt.path)
+		}
+		if r.auth != "Bearer secret" {
+			t.Errorf("request %d: authorization %q", i, r.auth)
+		}
+		for _, b := range tt.body {
+			if !strings.Contains(r.body, b) {
+				t.Errorf("request %d: %s not in %s", i, b, r.body)
+			}
+		}
+		if tt.body != nil && !json.Valid([]byte(r.body)) {
+			t.Errorf("request %d: not JSON: %s", i, r.body)
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	f := &fakeNoonlight{fail: true}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	c := NewClient(srv.URL, "secret")
+
+	if _, err := c.CreateAlarm(AlarmRequest{}); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("create: %v", err)
+	}
+	if err := c.Events("alarm-1", nil); err == nil {
+		t.Error("events did not fail")
+	}
+	if _, err := c.Status("alarm-1"); err == nil {
+		t.Error("status did not fail")
+	}
+	if err := c.Cancel("alarm-1", "1234"); err == nil {
+		t.Error("cancel did not fail")
+	}
+}
+
+// setupDispatch configures the platform against the fake, without HomeKit
+func setupDispatch(t *testing.T, f *fakeNoonlight) func() {
+	srv := httptest.NewServer(f)
+	info := accessory.Info{Name: "noonlight"}
+	noonlight = &tfaccessory.TFAccessory{
+		Name:      "noonlight",
+		Noonlight: &tfaccessory.NoonlightSettings{PIN: "1234", AuditLog: filepath.Join(t.TempDir(), "audit.log")},
+		Device:    devices.NewNoonlight(info),
+	}
+	client = NewClient(srv.URL, "secret")
+	return func() {
+		srv.Close()
+		active.mu.Lock()
+		active.id = ""
+		setState(devices.DispatchNone)
+		active.mu.Unlock()
+		noonlight = nil
+		client = nil
+	}
+}
+
+// drain waits for everything queued so far to be sent
+func drain() {
+	done := make(chan struct{})
+	queue <- func() error {
+		close(done)
+		return nil
+	}
+	<-done
+}
+
+var startWorker sync.Once
+
+func TestDispatch(t *testing.T) {
+	startWorker.Do(func() { go worker() })
+	f := &fakeNoonlight{}
+	defer setupDispatch(t, f)()
+
+	Alarm("house", "front")
+	Alarm("house", "back")
+	ZoneEvent("house", "hall", "motion", "detected")
+	drain()
+
+	var creates, events int
+	for _, r := range f.sent() {
+		switch r.path {
+		case "/dispatch/v1/alarms":
+			creates++
+		case "/dispatch/v1/alarms/alarm-1/events":
+			events++
+		default:
+			t.Errorf("unexpected request %s %s", r.method, r.path)
+		}
+	}
+	if creates != 1 {
+		t.Errorf("%d alarms created, the second zone should be added to the first", creates)
+	}
+	if events != 3 {
+		t.Errorf("%d events sent, want 3", events)
+	}
+
+	active.mu.Lock()
+	id, state := active.id, active.state
+	active.mu.Unlock()
+	if id != "alarm-1" || state != devices.DispatchActive {
+		t.Errorf("active %q in state %d", id, state)
+	}
+
+	Cancel("house")
+	drain()
+	sent := f.sent()
+	last := sent[len(sent)-1]
+	if last.path != "/dispatch/v1/alarms/alarm-1/status" || !strings.Contains(last.body, `"pin":"1234"`) {
+		t.Errorf("cancel sent %s %s", last.path, last.body)
+	}
+	active.mu.Lock()
+	id, state = active.id, active.state
+	active.mu.Unlock()
+	if id != "" || state != devices.DispatchCancelled {
+		t.Errorf("alarm %q in state %d after the cancel", id, state)
+	}
+}
+
+func TestCancelShown(t *testing.T) {
+	startWorker.Do(func() { go worker() })
+	f := &fakeNoonlight{}
+	defer setupDispatch(t, f)()
+	shown := cancelShown
+	cancelShown = 50 * time.Millisecond
+	defer func() { cancelShown = shown }()
+
+	dispatch := noonlight.Device.(*devices.Noonlight).Dispatch
+	Alarm("house", "front")
+	Cancel("house")
+	drain()
+	active.mu.Lock()
+	state := dispatch.DispatchState.GetValue()
+	active.mu.Unlock()
+	if state != devices.DispatchCancelled {
+		t.Fatalf("state %d after the cancel", state)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		active.mu.Lock()
+		state, contact := dispatch.DispatchState.GetValue(), dispatch.ContactSensorState.GetValue()
+		active.mu.Unlock()
+		if state == devices.DispatchNone {
+			if contact != characteristic.ContactSensorStateContactDetected {
+				t.Error("contact still open")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still showing state %d", state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a new alarm is not cleared by the last cancel
+	Alarm("house", "back")
+	Cancel("house")
+	Alarm("house", "back")
+	drain()
+	time.Sleep(100 * time.Millisecond)
+	active.mu.Lock()
+	defer active.mu.Unlock()
+	if active.state != devices.DispatchActive {
+		t.Errorf("new alarm in state %d", active.state)
+	}
+}
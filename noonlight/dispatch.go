@@ -0,0 +1,242 @@
+package noonlight
+
+import (
+	"fmt"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/log"
+	"github.com/brutella/hc/util"
+	"github.com/cloudkucooland/toofar/devices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the requests to Noonlight run in order, one at a time, so a cancel never passes the alarm it cancels
+var queue = make(chan func() error, 32)
+
+func worker() {
+	for f := range queue {
+		if err := f(); err != nil {
+			log.Info.Println(err.Error())
+		}
+	}
+}
+
+// the active alarm is saved so a restart can still add to or cancel it
+const activeKey = "alarm"
+
+type activemu struct {
+	mu      sync.Mutex
+	id      string // empty if no alarm is active
+	state   int    // one of the devices.Dispatch states
+	storage util.Storage
+	clear   *time.Timer // returns a cancelled alarm to none
+}
+
+// how long HomeKit shows an alarm as cancelled before it goes back to none
+var cancelShown = 10 * time.Minute
+
+var active activemu
+
+func loadActive() {
+	active.mu.Lock()
+	defer active.mu.Unlock()
+
+	storage, err := util.NewFileStorage("noonlight")
+	if err != nil {
+		log.Info.Printf("unable to get storage, the active noonlight alarm will not persist: %s", err.Error())
+		return
+	}
+	active.storage = storage
+	if b, err := storage.Get(activeKey); err == nil && len(b) > 0 {
+		active.id = string(b)
+		active.state = devices.DispatchActive
+		log.Info.Printf("noonlight alarm %s still active", active.id)
+	}
+}
+
+// setActive must be called with the lock held
+func setActive(id string) {
+	active.id = id
+	if active.storage == nil {
+		return
+	}
+	var err error
+	if id == "" {
+		err = active.storage.Delete(activeKey)
+	} else {
+		err = active.storage.Set(activeKey, []byte(id))
+	}
+	if err != nil {
+		log.Info.Println(err.Error())
+	}
+}
+
+// setState shows the dispatch state in HomeKit, it must be called with the lock held
+func setState(state int) {
+	active.state = state
+	if active.clear != nil {
+		active.clear.Stop()
+		active.clear = nil
+	}
+	if state == devices.DispatchCancelled {
+		var t *time.Timer
+		t = time.AfterFunc(cancelShown, func() {
+			active.mu.Lock()
+			defer active.mu.Unlock()
+			// replaced while waiting for the lock
+			if active.clear != t {
+				return
+			}
+			setState(devices.DispatchNone)
+		})
+		active.clear = t
+	}
+	if noonlight == nil {
+		return
+	}
+	d := noonlight.Device.(*devices.Noonlight).Dispatch
+	d.DispatchState.SetValue(state)
+	if state == devices.DispatchActive || state == devices.DispatchDispatched {
+		d.ContactSensorState.SetValue(characteristic.ContactSensorStateContactNotDetected)
+	} else {
+		d.ContactSensorState.SetValue(characteristic.ContactSensorStateContactDetected)
+	}
+}
+
+// Alarm is called when a security system's alarm sounds, source is the security system and zone is what tripped it
+// if an alarm is already active the zone is added to it
+func Alarm(source, zone string) {
+	queue <- func() error {
+		if client == nil {
+			return fmt.Errorf("noonlight not configured, not dispatching for %s: %s", source, zone)
+		}
+
+		active.mu.Lock()
+		defer active.mu.Unlock()
+
+		event := zoneEvent(source, zone, "alarm.device.activated_alarm", "alarm", "triggered")
+		if active.id != "" {
+			err := client.Events(active.id, []Event{event})
+			audit(auditRecord{Action: "event", Source: source, Zone: zone, Alarm: active.id}, err)
+			return err
+		}
+
+		a, err := client.CreateAlarm(alarmRequest())
+		if err != nil {
+			audit(auditRecord{Action: "dispatch", Source: source, Zone: zone}, err)
+			return err
+		}
+		log.Info.Printf("noonlight alarm %s created for %s: %s", a.ID, source, zone)
+		audit(auditRecord{Action: "dispatch", Source: source, Zone: zone, Alarm: a.ID, Status: a.Status}, nil)
+		setActive(a.ID)
+		setState(devices.DispatchActive)
+		return client.Events(a.ID, []Event{event})
+	}
+}
+
+// ZoneEvent adds more activity to the active alarm, it does nothing if no alarm is active
+func ZoneEvent(source, zone, attribute, value string) {
+	queue <- func() error {
+		active.mu.Lock()
+		defer active.mu.Unlock()
+
+		if client == nil || active.id == "" {
+			return nil
+		}
+		return client.Events(active.id, []Event{zoneEvent(source, zone, "alarm.device.value_changed", attribute, value)})
+	}
+}
+
+// Cancel ends the active alarm with the configured PIN, it does nothing if no alarm is active
+// source is what asked, the security system or the cancel switch
+func Cancel(source string) {
+	queue <- func() error {
+		active.mu.Lock()
+		defer active.mu.Unlock()
+
+		if client == nil || active.id == "" {
+			return nil
+		}
+		err := client.Cancel(active.id, noonlight.Noonlight.PIN)
+		audit(auditRecord{Action: "cancel", Source: source, Alarm: active.id}, err)
+		if err != nil {
+			return err
+		}
+		log.Info.Printf("noonlight alarm %s canceled by %s", active.id, source)
+		setActive("")
+		setState(devices.DispatchCancelled)
+		return nil
+	}
+}
+
+// checkStatus follows the active alarm on Noonlight's side, they can cancel it or send responders
+func checkStatus() {
+	queue <- func() error {
+		active.mu.Lock()
+		defer active.mu.Unlock()
+
+		if client == nil || active.id == "" {
+			return nil
+		}
+		status, err := client.Status(active.id)
+		if err != nil {
+			return err
+		}
+
+		state := devices.DispatchActive
+		switch {
+		case strings.HasPrefix(status, "CANCEL"):
+			state = devices.DispatchCancelled
+		case strings.Contains(status, "DISPATCH"):
+			state = devices.DispatchDispatched
+		}
+		if state == active.state {
+			return nil
+		}
+
+		log.Info.Printf("noonlight alarm %s is now %s", active.id, status)
+		audit(auditRecord{Action: "status", Alarm: active.id, Status: status}, nil)
+		if state == devices.DispatchCancelled {
+			setActive("")
+		}
+		setState(state)
+		return nil
+	}
+}
+
+func alarmRequest() AlarmRequest {
+	s := noonlight.Noonlight
+	req := AlarmRequest{
+		Name:     s.Name,
+		Phone:    s.Phone,
+		PIN:      s.PIN,
+		Location: Location{Address: s.Address},
+		Services: make(map[string]bool),
+	}
+	for _, svc := range s.Services {
+		req.Services[svc] = true
+	}
+	if len(req.Services) == 0 {
+		req.Services["police"] = true
+	}
+	if s.Instructions != "" {
+		req.Instructions = &AlarmInstructions{Entry: s.Instructions}
+	}
+	return req
+}
+
+func zoneEvent(source, zone, eventType, attribute, value string) Event {
+	return Event{
+		Type: eventType,
+		Time: time.Now().UTC(),
+		Meta: EventMeta{
+			Attribute:    attribute,
+			Value:        value,
+			DeviceID:     fmt.Sprintf("%s/%s", source, zone),
+			DeviceModel:  source,
+			DeviceName:   zone,
+			Manufacturer: "TooFar",
+		},
+	}
+}
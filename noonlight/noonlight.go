@@ -7,16 +7,25 @@ import (
 	"github.com/cloudkucooland/toofar/config"
 	"github.com/cloudkucooland/toofar/devices"
 	"github.com/cloudkucooland/toofar/platform"
+	"time"
 )
 
+// how often to ask Noonlight about the active alarm
+const statusCheck = 30 * time.Second
+
 // Platform is the platform handle for the Noonlight stuff
 type Platform struct {
-	noonlight *tfaccessory.TFAccessory
+	Running bool
 }
 
+// the single noonlight accessory and its client, nil until configured
+var noonlight *tfaccessory.TFAccessory
+var client *Client
+
 // Startup is called by the platform management to start the platform up
 func (p Platform) Startup(c *config.Config) platform.Control {
-	// nothing to do
+	loadActive()
+	go worker()
 	return p
 }
 
@@ -26,25 +35,55 @@ func (p Platform) Shutdown() platform.Control {
 	return p
 }
 
-// AddAccessory adds an envoy device, then adds it to HC
+// AddAccessory sets up the client, then adds the accessory to HC
 func (p Platform) AddAccessory(a *tfaccessory.TFAccessory) {
-	if p.noonlight != nil {
-		log.Info.Printf("noonlight already configured, ignoring new config; using: %+v", p.noonlight)
+	if noonlight != nil {
+		log.Info.Printf("noonlight already configured, ignoring new config; using: %s", noonlight.Name)
 		return
 	}
+	if a.Noonlight == nil {
+		log.Info.Printf("noonlight accessory [%s] has no Noonlight settings, not dispatching", a.Name)
+		a.Noonlight = &tfaccessory.NoonlightSettings{}
+	}
 
 	a.Type = accessory.TypeSensor
 	a.Info.Name = a.Name
-	a.Info.SerialNumber = "1234567"
+	if a.Info.SerialNumber == "" {
+		a.Info.SerialNumber = a.Name
+	}
 	a.Info.FirmwareRevision = "0.0"
 	a.Info.Model = "noonlight for TooFar"
 	a.Info.Manufacturer = "TooFar"
-	a.Info.ID = 1234567
+	if a.Info.ID == 0 {
+		// the ID it has always had, HomeKit keeps the room and automations by it
+		a.Info.ID = 1234567
+	}
 
 	a.Device = devices.NewNoonlight(a.Info)
 	a.Accessory = a.Device.(*devices.Noonlight).Accessory
 
-	p.noonlight = a
+	noonlight = a
+	if a.Password != "" {
+		client = NewClient(a.Noonlight.URL, a.Password)
+		log.Info.Printf("noonlight dispatching through %s", client.base)
+	}
+
+	active.mu.Lock()
+	setState(active.state)
+	active.mu.Unlock()
+
+	c := a.Device.(*devices.Noonlight).Cancel
+	c.On.OnValueRemoteUpdate(func(newstate bool) {
+		if !newstate {
+			return
+		}
+		if a.Noonlight.PIN == "" {
+			log.Info.Println("noonlight has no PIN set, unable to cancel")
+		} else {
+			Cancel("cancel switch")
+		}
+		c.On.SetValue(false)
+	})
 
 	h, _ := platform.GetPlatform("HomeControl")
 	h.AddAccessory(a)
@@ -52,9 +91,13 @@ func (p Platform) AddAccessory(a *tfaccessory.TFAccessory) {
 
 // GetAccessory returns the single noonlight accessory
 func (p Platform) GetAccessory(name string) (*tfaccessory.TFAccessory, bool) {
-	return p.noonlight, true
+	return noonlight, noonlight != nil
 }
 
 func (p Platform) Background() {
-	// nothing
+	go func() {
+		for range time.Tick(statusCheck) {
+			checkStatus()
+		}
+	}()
 }
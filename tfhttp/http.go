@@ -6,6 +6,7 @@ import (
 	"github.com/brutella/hc/log"
 	tfaccessory "github.com/cloudkucooland/toofar/accessory"
 	"github.com/cloudkucooland/toofar/config"
+	"github.com/cloudkucooland/toofar/kasa"
 	"github.com/cloudkucooland/toofar/konnected"
 	"github.com/cloudkucooland/toofar/platform"
 	"github.com/gorilla/mux"
@@ -27,7 +28,15 @@ func (h Platform) Startup(c *config.Config) platform.Control {
 	// each platform should register its own routes
 	r := mux.NewRouter()
 	r.HandleFunc("/", homeHandler)
+	r.HandleFunc("/kasa/discovered", kasa.DiscoveredHandler)
+	r.HandleFunc("/kasa/emeter", kasa.EmeterHandler)
+	r.HandleFunc("/kasa/emeter/{device}", kasa.EmeterHandler)
+	r.HandleFunc("/kasa/{device}/{module:schedule|anti_theft}", kasa.RulesHandler)
+	r.HandleFunc("/kasa/{device}/{module:schedule|anti_theft}/{id}", kasa.RulesHandler)
+	r.HandleFunc("/konnected/discovered", konnected.DiscoveredHandler)
+	r.HandleFunc("/konnected/rejected", konnected.RejectedHandler)
 	r.HandleFunc("/konnected/device/{device}", konnected.Handler)
+	r.HandleFunc("/konnected/provision/{device}", konnected.ProvisionHandler)
 	r.HandleFunc("/konnected/{device}", konnected.Handler)
 
 	// register some middleware to ensure that only local IP addresses can connect
@@ -78,5 +78,7 @@ func AddAccessory(h *accessory.TFAccessory) error {
 
 // StartHC is just a wrapper, no need to expose tfhc to the daemon
 func StartHC() {
+	// devices which moved while the bridge was down are still being looked for
+	kasa.WaitForMoves()
 	tfhc.StartHC()
 }